@@ -0,0 +1,32 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+)
+
+// apiCallTransport counts Docker API requests by call type (docker_api_calls_total), to show the load
+// the exporter puts on the daemon
+type apiCallTransport struct {
+    next http.RoundTripper
+}
+
+func (t *apiCallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    counterAPICalls.WithLabelValues(apiCallType(req.URL.Path)).Inc()
+    return t.next.RoundTrip(req)
+}
+
+// apiCallType classifies a request path (/v1.43/containers/<id>/stats, ...); suffixes only, so -api-path-prefix doesn't matter
+func apiCallType(path string) string {
+    switch {
+    case strings.HasSuffix(path, "/containers/json"): return "list"
+    case strings.HasSuffix(path, "/stats"): return "stats"
+    case strings.HasSuffix(path, "/top"): return "top"
+    case strings.HasSuffix(path, "/checkpoints"): return "checkpoints"
+    case strings.Contains(path, "/containers/") && strings.HasSuffix(path, "/json"): return "inspect"
+    case strings.HasSuffix(path, "/events"): return "events"
+    case strings.HasSuffix(path, "/info"): return "info"
+    case strings.HasSuffix(path, "/_ping"): return "ping"
+    }
+    return "other"
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "math"
+    "strconv"
+    "strings"
+)
+
+// calcCPUPercent returns the CPU usage between two snapshots in percent of a single core
+// (so up to onlineCPUs*100). ok is false when there is no usable delta: the container was idle,
+// its counter went backwards (restart) or the system counter didn't advance.
+func calcCPUPercent(cur, prev cpuSnapshot, onlineCPUs float64) (float64, bool) {
+    cpuDelta := float64(cur.totalUsage) - float64(prev.totalUsage)
+    systemDelta := float64(cur.systemUsage) - float64(prev.systemUsage)
+    if systemDelta <= 0 || cpuDelta <= 0 { return 0, false }
+    return (cpuDelta / systemDelta) * onlineCPUs * 100.0, true
+}
+
+// clampCPUPercent limits a CPU percentage to what onlineCPUs can deliver ([0, onlineCPUs*100]);
+// clamped is true when the value was out of range (timing glitches, clock adjustments).
+func clampCPUPercent(pct, onlineCPUs float64) (float64, bool) {
+    if upper := onlineCPUs * 100; pct > upper { return upper, true }
+    if pct < 0 { return 0, true }
+    return pct, false
+}
+
+// calcCPULimit resolves the ways Docker limits CPU (--cpus, --cpu-quota/--cpu-period, --cpuset-cpus) into the
+// number of cores the container can use: the tightest of those set, or onlineCPUs when none is.
+func calcCPULimit(nanoCPUs, quota, period int64, cpuset string, onlineCPUs float64) float64 {
+    limit := onlineCPUs
+    if nanoCPUs > 0 { limit = min(limit, float64(nanoCPUs)/1e9) }
+    if quota > 0 {
+        if period <= 0 { period = 100000 } // the kernel's default CFS period
+        limit = min(limit, float64(quota)/float64(period))
+    }
+    if n := cpusetSize(cpuset); n > 0 { limit = min(limit, float64(n)) }
+    return limit
+}
+
+// cpusetSize counts the CPUs in a cpuset list such as "0-3,6" (0 when empty or invalid)
+func cpusetSize(cpuset string) int {
+    n := 0
+    for _, part := range strings.Split(cpuset, ",") {
+        if part = strings.TrimSpace(part); part == "" { continue }
+        lo, hi, isRange := strings.Cut(part, "-")
+        if !isRange { hi = lo }
+        first, err1 := strconv.Atoi(lo)
+        last, err2 := strconv.Atoi(hi)
+        if err1 != nil || err2 != nil || last < first { return 0 }
+        n += last - first + 1
+    }
+    return n
+}
+
+// calcEMA blends a new value into an exponential moving average; alpha in (0, 1] is the weight of the new value
+func calcEMA(value, prev, alpha float64) float64 {
+    return alpha*value + (1-alpha)*prev
+}
+
+// calcCounterDelta converts two readings of an absolute counter (e.g. network bytes) into an increment.
+// ok is false when the counter went backwards (container restart, network namespace change);
+// the caller should then simply take cur as the new baseline.
+func calcCounterDelta(cur, prev uint64) (uint64, bool) {
+    if cur < prev { return 0, false }
+    return cur - prev, true
+}
+
+// calcNetDelta is calcCounterDelta for a network interface byte counter. Some kernels keep these in
+// 32 bits, wrapping at 4GiB; with wrap32 set, a drop from the top quarter of the 32 bit range into the
+// bottom quarter is counted as a wrap (and its bytes added) instead of being treated as a reset.
+func calcNetDelta(cur, prev uint64, wrap32 bool) (uint64, bool) {
+    if d, ok := calcCounterDelta(cur, prev); ok { return d, true }
+    if wrap32 && prev <= math.MaxUint32 && prev >= 3<<30 && cur < 1<<30 {
+        return (math.MaxUint32 - prev) + cur + 1, true
+    }
+    return 0, false
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+    "math"
+    "testing"
+)
+
+func TestCalcCPUPercent(t *testing.T) {
+    tests := []struct {
+        name       string
+        cur, prev  cpuSnapshot
+        onlineCPUs float64
+        want       float64
+        ok         bool
+    }{
+        {"quarter of the host on 2 CPUs", cpuSnapshot{totalUsage: 1.5e9, systemUsage: 1.2e10}, cpuSnapshot{totalUsage: 1e9, systemUsage: 1e10}, 2, 50, true},
+        {"whole host on 4 CPUs", cpuSnapshot{totalUsage: 2e9, systemUsage: 2e9}, cpuSnapshot{}, 4, 400, true},
+        {"idle", cpuSnapshot{totalUsage: 1e9, systemUsage: 2e10}, cpuSnapshot{totalUsage: 1e9, systemUsage: 1e10}, 2, 0, false},
+        {"counter reset by a restart", cpuSnapshot{totalUsage: 1e6, systemUsage: 2e10}, cpuSnapshot{totalUsage: 1e9, systemUsage: 1e10}, 2, 0, false},
+        {"system counter stalled", cpuSnapshot{totalUsage: 2e9, systemUsage: 1e10}, cpuSnapshot{totalUsage: 1e9, systemUsage: 1e10}, 2, 0, false},
+        {"system counter went backwards", cpuSnapshot{totalUsage: 2e9, systemUsage: 1e9}, cpuSnapshot{totalUsage: 1e9, systemUsage: 1e10}, 2, 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, ok := calcCPUPercent(tt.cur, tt.prev, tt.onlineCPUs)
+            if got != tt.want || ok != tt.ok { t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.ok) }
+        })
+    }
+}
+
+func TestClampCPUPercent(t *testing.T) {
+    tests := []struct {
+        name    string
+        pct     float64
+        want    float64
+        clamped bool
+    }{
+        {"in range", 150, 150, false},
+        {"at the upper bound", 200, 200, false},
+        {"glitchy delta", 350, 200, true},
+        {"negative", -5, 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, clamped := clampCPUPercent(tt.pct, 2)
+            if got != tt.want || clamped != tt.clamped { t.Errorf("got %v, %v, want %v, %v", got, clamped, tt.want, tt.clamped) }
+        })
+    }
+}
+
+// Each way of limiting CPU on an 8 CPU host, and the tightest one winning when several are set
+func TestCalcCPULimit(t *testing.T) {
+    tests := []struct {
+        name          string
+        nanoCPUs      int64
+        quota, period int64
+        cpuset        string
+        want          float64
+    }{
+        {"no limit", 0, 0, 0, "", 8},
+        {"--cpus", 1.5e9, 0, 0, "", 1.5},
+        {"--cpu-quota with the default period", 0, 50000, 0, "", 0.5},
+        {"--cpu-quota and --cpu-period", 0, 300000, 200000, "", 1.5},
+        {"--cpuset-cpus", 0, 0, 0, "0-2,5", 4},
+        {"--cpus above the host", 16e9, 0, 0, "", 8},
+        {"tightest wins", 2e9, 0, 0, "0", 1},
+        {"invalid cpuset ignored", 2e9, 0, 0, "3-1", 2},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := calcCPULimit(tt.nanoCPUs, tt.quota, tt.period, tt.cpuset, 8); got != tt.want { t.Errorf("got %v, want %v", got, tt.want) }
+        })
+    }
+}
+
+func TestCpusetSize(t *testing.T) {
+    tests := []struct {
+        cpuset string
+        want   int
+    }{
+        {"", 0},
+        {"0", 1},
+        {"0-3", 4},
+        {"0-3,6", 5},
+        {" 1, 4-5 ", 3},
+        {"3-1", 0},
+        {"a-b", 0},
+    }
+    for _, tt := range tests {
+        if got := cpusetSize(tt.cpuset); got != tt.want { t.Errorf("cpusetSize(%q) = %v, want %v", tt.cpuset, got, tt.want) }
+    }
+}
+
+func TestCalcEMA(t *testing.T) {
+    tests := []struct {
+        name               string
+        value, prev, alpha float64
+        want               float64
+    }{
+        {"0.3 * 100 + 0.7 * 50", 100, 50, 0.3, 65},
+        {"alpha 1 is raw", 100, 50, 1, 100},
+        {"steady value", 40, 40, 0.5, 40},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := calcEMA(tt.value, tt.prev, tt.alpha); math.Abs(got-tt.want) > 1e-9 { t.Errorf("got %v, want %v", got, tt.want) }
+        })
+    }
+}
+
+func TestCalcCounterDelta(t *testing.T) {
+    tests := []struct {
+        name      string
+        cur, prev uint64
+        want      uint64
+        ok        bool
+    }{
+        {"increase", 1500, 1000, 500, true},
+        {"unchanged", 1000, 1000, 0, true},
+        {"reset", 10, 1000, 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, ok := calcCounterDelta(tt.cur, tt.prev)
+            if got != tt.want || ok != tt.ok { t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.ok) }
+        })
+    }
+}
+
+func TestCalcNetDelta(t *testing.T) {
+    tests := []struct {
+        name      string
+        cur, prev uint64
+        wrap32    bool
+        want      uint64
+        ok        bool
+    }{
+        {"increase", 4000, 1000, false, 3000, true},
+        {"32 bit wrap", 5, math.MaxUint32 - 9, true, 15, true},
+        {"32 bit wrap without -net-wrap32 is a reset", 5, math.MaxUint32 - 9, false, 0, false},
+        {"drop from the middle of the range is a reset", 5, 1 << 31, true, 0, false},
+        {"drop to the upper range is a reset", 2 << 30, math.MaxUint32 - 9, true, 0, false},
+        {"64 bit counter never wraps at 2^32", 5, 1 << 40, true, 0, false},
+        // Past 2^53 the totals aren't exact as float64 anymore, the deltas must still be
+        {"beyond 2^53 bytes", 1<<60 + 1001, 1<<60 + 1, false, 1000, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, ok := calcNetDelta(tt.cur, tt.prev, tt.wrap32)
+            if got != tt.want || ok != tt.ok { t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.ok) }
+        })
+    }
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/docker/docker/api/types"
+    "github.com/docker/docker/client"
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Set in main when -checkpoints is on and the daemon supports checkpoints (experimental)
+var checkpointsAvailable bool
+
+// Last CheckpointList call per container
+var (
+    checkpointHistory = make(map[string]time.Time)
+    checkpointMutex   sync.Mutex
+)
+
+// detectCheckpoints reports whether the daemon runs with experimental features, which checkpoint/restore needs
+func detectCheckpoints(cli *client.Client) bool {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    info, err := cli.Info(ctx)
+    cancel()
+    if err != nil {
+        log.Printf("WARN: Info: %v, checkpoint metrics disabled", err)
+        return false
+    }
+    if !info.ExperimentalBuild {
+        logInfo("Docker daemon has no experimental features, checkpoint metrics disabled")
+        return false
+    }
+    return true
+}
+
+// collectCheckpoints exports the number of checkpoints of a container, at most once per -meta-interval.
+// Failures are skipped and retried on the next tick.
+func collectCheckpoints(ctx context.Context, cli *client.Client, cid string, labels prometheus.Labels) {
+    checkpointMutex.Lock()
+    last, found := checkpointHistory[cid]
+    checkpointMutex.Unlock()
+    if found && time.Since(last) < *metaInterval { return }
+
+    checkpoints, err := cli.CheckpointList(ctx, cid, types.CheckpointListOptions{})
+    if err != nil { return }
+    setAggregated(gaugeCheckpoints, labels, cid, float64(len(checkpoints)), aggSum)
+
+    checkpointMutex.Lock()
+    checkpointHistory[cid] = time.Now()
+    checkpointMutex.Unlock()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// How the per-container values of a collapsed series are combined
+type aggregation int
+
+const (
+    aggSum aggregation = iota
+    aggMax
+)
+
+// collapsedGauges combines gauge values of all containers sharing a collapsed label set (-collapse-by).
+// The latest value of every container is kept, so containers skipped on a tick (sampling) or collected
+// out of band (events) are neither lost nor counted twice; flush exports the combined values.
+type collapsedGauges struct {
+    mu     sync.Mutex
+    ops    map[*prometheus.GaugeVec]aggregation
+    values map[*prometheus.GaugeVec]map[string]map[string]float64 // vec -> series key -> container id -> value
+    labels map[string]prometheus.Labels                           // series key -> labels
+}
+
+var collapsed = &collapsedGauges{
+    ops:    make(map[*prometheus.GaugeVec]aggregation),
+    values: make(map[*prometheus.GaugeVec]map[string]map[string]float64),
+    labels: make(map[string]prometheus.Labels),
+}
+
+// collapsing reports whether containers are combined into shared series (-collapse-by or -aggregate-by-label)
+func collapsing() bool {
+    return *collapseBy != "none" || *aggregateBy != ""
+}
+
+// setAggregated sets a per-container gauge; when collapsing the value is combined with the
+// other containers of the same series (see collapsedGauges) instead of overwriting them.
+func setAggregated(vec *prometheus.GaugeVec, labels prometheus.Labels, cid string, value float64, op aggregation) {
+    if !collapsing() {
+        setGauge(vec, labels, value)
+        return
+    }
+    collapsed.set(vec, labels, cid, value, op)
+}
+
+func (g *collapsedGauges) set(vec *prometheus.GaugeVec, labels prometheus.Labels, cid string, value float64, op aggregation) {
+    key := labelsKey(labels)
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    g.ops[vec] = op
+    if g.values[vec] == nil { g.values[vec] = make(map[string]map[string]float64) }
+    if g.values[vec][key] == nil { g.values[vec][key] = make(map[string]float64) }
+    g.values[vec][key][cid] = value
+    g.labels[key] = labels
+}
+
+// flush exports the combined value of every series and drops series without containers left
+func (g *collapsedGauges) flush() {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    for vec, series := range g.values {
+        for key, byContainer := range series {
+            if len(byContainer) == 0 {
+                vec.Delete(g.labels[key])
+                delete(series, key)
+                continue
+            }
+            var total float64
+            first := true
+            for _, v := range byContainer {
+                switch {
+                case g.ops[vec] == aggSum:
+                    total += v
+                case first || v > total:
+                    total = v
+                }
+                first = false
+            }
+            setGauge(vec, g.labels[key], total)
+        }
+    }
+}
+
+// forget removes the contributions of a gone container; its series disappear on the next flush if it was the last one
+func (g *collapsedGauges) forget(cid string) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    for _, series := range g.values {
+        for _, byContainer := range series { delete(byContainer, cid) }
+    }
+}
+
+// labelsKey is a stable string form of a label set
+func labelsKey(labels prometheus.Labels) string {
+    pairs := make([]string, 0, len(labels))
+    for k, v := range labels { pairs = append(pairs, k+"="+v) }
+    sort.Strings(pairs)
+    return strings.Join(pairs, "\x00")
+}
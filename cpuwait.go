@@ -0,0 +1,70 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Set in main when -cpu-wait is on and the daemon is local
+var cpuWaitAvailable bool
+
+// Last summed run queue delay (ns) per container, for delta conversion
+var (
+    cpuWaitHistory = make(map[string]uint64)
+    cpuWaitMutex   sync.Mutex
+)
+
+// collectCPUWait exports the time the container's threads spent runnable but waiting for a CPU
+// (run queue delay from /proc/<tid>/schedstat), which includes steal on virtualized hosts.
+// Docker's stats don't carry it, so this reads the host's /proc and cgroup filesystem directly.
+func collectCPUWait(cid string, pid int, labels prometheus.Labels) {
+    if pid <= 0 { return }
+    tids, err := cgroupThreads(cid, pid)
+    if err != nil { return }
+
+    var total uint64
+    for _, tid := range tids {
+        raw, err := os.ReadFile("/proc/" + tid + "/schedstat")
+        if err != nil { continue } // thread exited meanwhile
+        // time on cpu, time waiting on a run queue, timeslices (all ns)
+        fields := strings.Fields(string(raw))
+        if len(fields) < 2 { continue }
+        if wait, err := strconv.ParseUint(fields[1], 10, 64); err == nil { total += wait }
+    }
+
+    cpuWaitMutex.Lock()
+    prev, found := cpuWaitHistory[cid]
+    cpuWaitHistory[cid] = total
+    cpuWaitMutex.Unlock()
+    // Exited threads take their share with them; a drop only sets a new baseline
+    if d, ok := calcCounterDelta(total, prev); found && ok && d > 0 { counterCPUWait.With(labels).Add(float64(d) / 1e9) }
+}
+
+// cgroupThreads lists the thread ids in the cgroup of a container's process (cgroup v2, or the cpu controller on v1)
+func cgroupThreads(cid string, pid int) ([]string, error) {
+    raw, err := containerProcCgroup(pid, cid)
+    if err != nil { return nil, err }
+
+    // On hybrid hosts both show up; the v1 cpu controller is the one holding the tasks then
+    var v1, v2 string
+    for _, line := range strings.Split(strings.TrimSpace(raw), "\n") {
+        parts := strings.SplitN(line, ":", 3)
+        if len(parts) != 3 { continue }
+        if parts[0] == "0" && parts[1] == "" { v2 = filepath.Join("/sys/fs/cgroup", parts[2], "cgroup.threads") }
+        for _, ctrl := range strings.Split(parts[1], ",") {
+            if ctrl == "cpu" { v1 = filepath.Join("/sys/fs/cgroup/cpu", parts[2], "tasks") }
+        }
+    }
+    file := v1
+    if file == "" { file = v2 }
+    if file == "" { return nil, os.ErrNotExist }
+
+    tids, err := os.ReadFile(file)
+    if err != nil { return nil, err }
+    return strings.Fields(string(tids)), nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+    "net/http"
+
+    "golang.org/x/time/rate"
+)
+
+// daemonRateTransport caps the requests per second sent to the daemon (-daemon-rps), whatever the number of
+// workers; requests over the limit wait for their turn and are counted in daemon_rps_throttled_total
+type daemonRateTransport struct {
+    next    http.RoundTripper
+    limiter *rate.Limiter
+}
+
+func newDaemonRateTransport(next http.RoundTripper, rps float64) *daemonRateTransport {
+    return &daemonRateTransport{next: next, limiter: rate.NewLimiter(rate.Limit(rps), max(1, int(rps)))}
+}
+
+func (t *daemonRateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    if !t.limiter.Allow() {
+        counterThrottled.Inc()
+        if err := t.limiter.Wait(req.Context()); err != nil { return nil, err }
+    }
+    return t.next.RoundTrip(req)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "sort"
+    "time"
+
+    "github.com/docker/docker/client"
+    "github.com/docker/docker/errdefs"
+)
+
+// Snapshot of the internal delta tracking state of one container, as served by /debug/history
+type historyEntry struct {
+    ID         string    `json:"id"`
+    Name       string    `json:"name"`
+    LastSeen   time.Time `json:"last_seen"`
+    CPUTotal   uint64    `json:"cpu_total_usage"`
+    CPUSystem  uint64    `json:"cpu_system_usage"`
+    NetRxBytes *uint64   `json:"net_rx_bytes,omitempty"`
+    NetTxBytes *uint64   `json:"net_tx_bytes,omitempty"`
+}
+
+// handleDebugHistory dumps cpuHistory and netHistory as JSON, sorted by container name
+func handleDebugHistory(w http.ResponseWriter, r *http.Request) {
+    historyMutex.RLock()
+    entries := make([]historyEntry, 0, len(cpuHistory))
+    for id, snap := range cpuHistory {
+        entries = append(entries, historyEntry{
+            ID:        id,
+            Name:      snap.name,
+            LastSeen:  snap.lastSeen,
+            CPUTotal:  snap.totalUsage,
+            CPUSystem: snap.systemUsage,
+        })
+    }
+    historyMutex.RUnlock()
+
+    netMutex.RLock()
+    for i := range entries {
+        if n, ok := netHistory[entries[i].ID]; ok {
+            entries[i].NetRxBytes = &n.rxBytes
+            entries[i].NetTxBytes = &n.txBytes
+        }
+    }
+    netMutex.RUnlock()
+
+    sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+    w.Header().Set("Content-Type", "application/json")
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    enc.Encode(entries)
+}
+
+// handleRawStats serves the undecoded stats of one container (?id=, full or short id, or name) exactly as the
+// daemon returns them, to check what Docker reports when the exported values look wrong
+func handleRawStats(cli *client.Client) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id := r.URL.Query().Get("id")
+        if id == "" {
+            http.Error(w, "Missing id parameter", http.StatusBadRequest)
+            return
+        }
+        stats, err := cli.ContainerStatsOneShot(r.Context(), id)
+        if err != nil {
+            status := http.StatusBadGateway
+            if errdefs.IsNotFound(err) { status = http.StatusNotFound }
+            http.Error(w, err.Error(), status)
+            return
+        }
+        defer stats.Body.Close()
+        w.Header().Set("Content-Type", "application/json")
+        io.Copy(w, stats.Body)
+    }
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+    "errors"
+    "runtime"
+)
+
+// diskFree is not implemented on this platform
+func diskFree(path string) (uint64, error) {
+    return 0, errors.New("not supported on " + runtime.GOOS)
+}
+
+// diskUsage is not implemented on this platform
+func diskUsage(path string) (uint64, uint64, error) {
+    return 0, 0, errors.New("not supported on " + runtime.GOOS)
+}
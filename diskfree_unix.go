@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "syscall"
+
+// diskFree returns the bytes available to unprivileged users on the filesystem holding path
+func diskFree(path string) (uint64, error) {
+    var st syscall.Statfs_t
+    if err := syscall.Statfs(path, &st); err != nil { return 0, err }
+    return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// diskUsage returns the size and used bytes of the filesystem (or project quota) holding path
+func diskUsage(path string) (size, used uint64, err error) {
+    var st syscall.Statfs_t
+    if err := syscall.Statfs(path, &st); err != nil { return 0, 0, err }
+    return uint64(st.Blocks) * uint64(st.Bsize), (uint64(st.Blocks) - uint64(st.Bfree)) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "path/filepath"
+
+    "github.com/docker/docker/client"
+    "github.com/docker/go-connections/tlsconfig"
+)
+
+// Endpoint part of a Docker CLI context (contexts/meta/<sha256 of name>/meta.json)
+type contextMeta struct {
+    Name      string
+    Endpoints map[string]struct {
+        Host          string
+        SkipTLSVerify bool
+    }
+}
+
+// dockerConfigDir is where the Docker CLI keeps its config and context store
+func dockerConfigDir() (string, error) {
+    if dir := os.Getenv("DOCKER_CONFIG"); dir != "" { return dir, nil }
+    home, err := os.UserHomeDir()
+    if err != nil { return "", err }
+    return filepath.Join(home, ".docker"), nil
+}
+
+// contextOpts resolves a Docker CLI context (like docker --context) into client options:
+// the endpoint host plus the TLS material stored alongside it, if any.
+func contextOpts(name string) (string, []client.Opt, error) {
+    // The built-in default context isn't stored, it's the environment (DOCKER_HOST) or the local socket
+    if name == "default" { return client.DefaultDockerHost, []client.Opt{client.FromEnv}, nil }
+
+    dir, err := dockerConfigDir()
+    if err != nil { return "", nil, err }
+    sum := sha256.Sum256([]byte(name))
+    id := hex.EncodeToString(sum[:])
+
+    raw, err := os.ReadFile(filepath.Join(dir, "contexts", "meta", id, "meta.json"))
+    if errors.Is(err, os.ErrNotExist) {
+        return "", nil, fmt.Errorf("context %q not found in %s (see 'docker context ls')", name, filepath.Join(dir, "contexts"))
+    } else if err != nil {
+        return "", nil, err
+    }
+    var meta contextMeta
+    if err := json.Unmarshal(raw, &meta); err != nil { return "", nil, fmt.Errorf("context %q: %w", name, err) }
+    ep, ok := meta.Endpoints["docker"]
+    if !ok || ep.Host == "" { return "", nil, fmt.Errorf("context %q has no docker endpoint", name) }
+
+    opts := []client.Opt{client.WithHost(ep.Host)}
+
+    // TLS files are optional and individually present (e.g. only a CA for a server-verified endpoint)
+    tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+    file := func(name string) string {
+        p := filepath.Join(tlsDir, name)
+        if _, err := os.Stat(p); err != nil { return "" }
+        return p
+    }
+    ca, cert, key := file("ca.pem"), file("cert.pem"), file("key.pem")
+    if ca != "" || cert != "" || ep.SkipTLSVerify {
+        cfg, err := tlsconfig.Client(tlsconfig.Options{
+            CAFile:             ca,
+            CertFile:           cert,
+            KeyFile:            key,
+            ExclusiveRootPools: ca != "",
+            InsecureSkipVerify: ep.SkipTLSVerify,
+        })
+        if err != nil { return "", nil, fmt.Errorf("context %q: %w", name, err) }
+        opts = append(opts, withTransport(func(next http.RoundTripper) (http.RoundTripper, error) {
+            t, ok := next.(*http.Transport)
+            if !ok { return nil, fmt.Errorf("cannot apply TLS config to %T", next) }
+            t.TLSClientConfig = cfg
+            return t, nil
+        }))
+    }
+    return ep.Host, opts, nil
+}
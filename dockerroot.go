@@ -0,0 +1,47 @@
+package main
+
+import (
+    "context"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/docker/docker/client"
+)
+
+// watchDockerRoot periodically exports the free space of the daemon's data root (images, layers,
+// container filesystems). Only meaningful for a local daemon whose DockerRootDir is visible to us.
+func watchDockerRoot(cli *client.Client) {
+    if !strings.HasPrefix(cli.DaemonHost(), "unix://") {
+        logInfo("Docker daemon is not local, not exporting docker_root_free_bytes")
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    info, err := cli.Info(ctx)
+    cancel()
+    if err != nil {
+        log.Printf("ERROR: Info: %v", err)
+        return
+    }
+
+    free, err := diskFree(info.DockerRootDir)
+    if err != nil {
+        log.Printf("WARN: Can't read free space of %s, not exporting docker_root_free_bytes: %v", info.DockerRootDir, err)
+        return
+    }
+    gaugeRootFree.Set(float64(free))
+    mustRegister(gaugeRootFree)
+
+    // Same cadence as the other slow-changing data, but never more often than the polling interval
+    every := max(*metaInterval, time.Duration(*interval)*time.Second)
+    for {
+        time.Sleep(every)
+        free, err := diskFree(info.DockerRootDir)
+        if err != nil {
+            log.Printf("WARN: Can't read free space of %s: %v", info.DockerRootDir, err)
+            continue
+        }
+        gaugeRootFree.Set(float64(free))
+    }
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/docker/docker/api/types"
+    "github.com/docker/docker/api/types/events"
+    "github.com/docker/docker/api/types/filters"
+    "github.com/docker/docker/client"
+)
+
+// watchEvents follows the Docker events stream and reacts to container lifecycle changes
+// between polling ticks. The subscription is re-established after errors.
+func watchEvents(cli *client.Client) {
+    for {
+        // Don't keep resubscribing (and logging errors) while the daemon is down for maintenance
+        if pollingPaused.Load() {
+            time.Sleep(5 * time.Second)
+            continue
+        }
+        ctx, cancel := context.WithCancel(context.Background())
+        msgs, errs := cli.Events(ctx, types.EventsOptions{
+            Filters: filters.NewArgs(
+                filters.Arg("type", string(events.ContainerEventType)),
+                filters.Arg("event", "start"),
+                filters.Arg("event", "die"),
+                filters.Arg("event", "destroy"),
+            ),
+        })
+
+    loop:
+        for {
+            select {
+            case msg := <-msgs:
+                handleEvent(cli, msg)
+            case err := <-errs:
+                log.Printf("ERROR: Events stream: %v", err)
+                break loop
+            }
+        }
+        cancel()
+        time.Sleep(5 * time.Second)
+    }
+}
+
+// Containers that died and haven't been started again (or removed) yet, and recent restart times
+// per container for crash-loop detection; both guarded by diedMutex
+var (
+    diedContainers = make(map[string]bool)
+    restartTimes   = make(map[string][]time.Time)
+    diedMutex      sync.Mutex
+)
+
+// How long the name of a removed container is remembered, so a container started under it again counts as recreated
+const recreateWindow = 24 * time.Hour
+
+// Last id seen under each container name, with the time it was removed (zero while it exists); guarded by diedMutex
+type nameRecord struct {
+    id      string
+    removed time.Time
+}
+
+var nameIDs = make(map[string]nameRecord)
+
+func handleEvent(cli *client.Client, msg events.Message) {
+    cid, name := msg.Actor.ID, msg.Actor.Attributes["name"]
+    switch msg.Action {
+    case "die":
+        diedMutex.Lock()
+        diedContainers[cid] = true
+        diedMutex.Unlock()
+    case "destroy":
+        diedMutex.Lock()
+        delete(diedContainers, cid)
+        delete(restartTimes, cid)
+        if name != "" { nameIDs[name] = nameRecord{id: cid, removed: time.Now()} }
+        for n, rec := range nameIDs {
+            if rec.removed.IsZero() || time.Since(rec.removed) <= recreateWindow { continue }
+            // The name is gone for good (e.g. a CI throwaway), so is its series
+            delete(nameIDs, n)
+            counterRecreated.DeleteLabelValues(n)
+        }
+        diedMutex.Unlock()
+    case "start":
+        diedMutex.Lock()
+        restarted := diedContainers[cid]
+        delete(diedContainers, cid)
+        if restarted { restartTimes[cid] = append(restartTimes[cid], time.Now()) }
+        prev, known := nameIDs[name]
+        recreated := known && prev.id != cid
+        if name != "" { nameIDs[name] = nameRecord{id: cid} }
+        diedMutex.Unlock()
+        if !pollingPaused.Load() { go onContainerStart(cli, cid, name, restarted, recreated) }
+    }
+}
+
+// onContainerStart collects a (re)started container out of band, applying the same selection as
+// a regular tick, so its CPU baseline exists before the next tick. recreated means an earlier container
+// with the same name was seen under another id.
+func onContainerStart(cli *client.Client, cid, name string, restarted, recreated bool) {
+    ctx := context.Background()
+    containers, err := cli.ContainerList(ctx, types.ContainerListOptions{Filters: filters.NewArgs(filters.Arg("id", cid))})
+    if err != nil {
+        log.Printf("ERROR: ContainerList: %v", err)
+        return
+    }
+    for _, c := range containers {
+        if c.ID != cid || !collectable(c) { continue }
+        if restarted {
+            labels := containerLabels(c, containerName(c))
+            counterObservedRestarts.With(labels).Inc()
+            setAggregated(gaugeLastRestart, labels, cid, float64(time.Now().Unix()), aggMax)
+            setAggregated(gaugeCrashloop, labels, cid, crashLooping(cid), aggMax)
+        }
+        if recreated { counterRecreated.WithLabelValues(name).Inc() }
+        collectExclusive(ctx, cli, c)
+    }
+}
+
+// crashLooping returns 1 when the container restarted at least -crashloop-restarts times within -crashloop-window
+func crashLooping(cid string) float64 {
+    diedMutex.Lock()
+    defer diedMutex.Unlock()
+    recent := restartTimes[cid][:0]
+    for _, t := range restartTimes[cid] {
+        if time.Since(t) <= *crashWindow { recent = append(recent, t) }
+    }
+    if len(recent) == 0 {
+        delete(restartTimes, cid)
+        return 0
+    }
+    restartTimes[cid] = recent
+    if len(recent) >= *crashRestarts { return 1 }
+    return 0
+}
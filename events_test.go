@@ -0,0 +1,36 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/docker/docker/api/types/events"
+)
+
+// Names removed longer than recreateWindow ago are forgotten along with their recreation counter
+func TestRecreatedSeriesExpire(t *testing.T) {
+    diedMutex.Lock()
+    nameIDs["ci-old"] = nameRecord{id: "old", removed: time.Now().Add(-recreateWindow - time.Minute)}
+    nameIDs["ci-recent"] = nameRecord{id: "recent", removed: time.Now().Add(-time.Hour)}
+    nameIDs["web"] = nameRecord{id: "web"}
+    diedMutex.Unlock()
+    for _, name := range []string{"ci-old", "ci-recent", "web"} { counterRecreated.WithLabelValues(name).Inc() }
+
+    handleEvent(nil, events.Message{Action: "destroy", Actor: events.Actor{ID: "other", Attributes: map[string]string{"name": "other"}}})
+
+    tests := []struct {
+        name string
+        kept bool
+    }{
+        {"ci-old", false},
+        {"ci-recent", true},
+        {"web", true},
+    }
+    diedMutex.Lock()
+    defer diedMutex.Unlock()
+    for _, tt := range tests {
+        _, known := nameIDs[tt.name]
+        deleted := !counterRecreated.DeleteLabelValues(tt.name)
+        if known != tt.kept || deleted == tt.kept { t.Errorf("%s: name kept %v, series kept %v, want %v", tt.name, known, !deleted, tt.kept) }
+    }
+}
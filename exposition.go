@@ -0,0 +1,130 @@
+package main
+
+import (
+    "bytes"
+    "log"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+    dto "github.com/prometheus/client_model/go"
+    "github.com/prometheus/common/expfmt"
+)
+
+// Metrics whose values come straight from a container's stats read. Everything else keeps the scrape time:
+// inspect data, event counters and values computed at scrape time (container_data_age_seconds) change
+// without a new read, and Prometheus rejects a changed value under an already seen timestamp.
+var statsReadMetrics = map[string]bool{
+    appName + "_cpu_usage_ratio":                   true,
+    appName + "_memory_usage_bytes":                true,
+    appName + "_memory_usage_rss_bytes":            true,
+    appName + "_memory_limit_bytes":                true,
+    appName + "_memory_usage_ratio":                true,
+    appName + "_network_received_bytes_total":      true,
+    appName + "_network_transmitted_bytes_total":   true,
+    appName + "_network_receive_bytes_per_second":  true,
+    appName + "_network_transmit_bytes_per_second": true,
+    appName + "_blockio_read_bytes":                true,
+    appName + "_blockio_written_bytes":             true,
+    appName + "_container_mapped_file_bytes":       true,
+    appName + "_container_pgfault_total":           true,
+    appName + "_container_pgmajfault_total":        true,
+}
+
+// timestampedGatherer stamps the container series of statsReadMetrics with the instant Docker read
+// the stats (v.Read), so irregular scrapes still carry the true collection time.
+func timestampedGatherer(g prometheus.Gatherer) prometheus.Gatherer {
+    return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+        mfs, err := g.Gather()
+
+        historyMutex.RLock()
+        reads := make(map[string]int64, len(cpuHistory))
+        for id, snap := range cpuHistory {
+            if !snap.readTime.IsZero() { reads[id[:12]] = snap.readTime.UnixMilli() }
+        }
+        historyMutex.RUnlock()
+
+        for _, mf := range mfs {
+            if !statsReadMetrics[mf.GetName()] { continue }
+            for _, m := range mf.Metric {
+                for _, lp := range m.Label {
+                    if lp.GetName() != "id" { continue }
+                    if ts, ok := reads[lp.GetValue()]; ok { m.TimestampMs = &ts }
+                }
+            }
+        }
+        return mfs, err
+    })
+}
+
+// dataAgeCollector exports container_data_age_seconds, the time since each container was last collected,
+// computed at scrape time since polling and scraping aren't synchronized. Containers skipped on a tick
+// (sampling, dockerstats.interval) or failing their stats calls age accordingly.
+type dataAgeCollector struct {
+    desc *prometheus.Desc
+}
+
+func newDataAgeCollector() *dataAgeCollector {
+    return &dataAgeCollector{desc: prometheus.NewDesc(appName+"_container_data_age_seconds", "", slices.Clone(containerLabelNames), nil)}
+}
+
+func (c *dataAgeCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+
+func (c *dataAgeCollector) Collect(ch chan<- prometheus.Metric) {
+    // Containers sharing a series (-collapse-by, -aggregate-by-label) report the stalest of them
+    ages := make(map[string]float64)
+    values := make(map[string][]string)
+    historyMutex.RLock()
+    for _, snap := range cpuHistory {
+        key := labelsKey(snap.labels)
+        age := time.Since(snap.collectedAt).Seconds()
+        if prev, ok := ages[key]; ok && prev >= age { continue }
+        ages[key] = age
+        lv := make([]string, len(containerLabelNames))
+        for i, name := range containerLabelNames { lv[i] = snap.labels[name] }
+        values[key] = lv
+    }
+    historyMutex.RUnlock()
+
+    for key, age := range ages { ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, age, values[key]...) }
+}
+
+// metricsHandler serves a gatherer like promhttp. With -openmetrics, scrapers asking for OpenMetrics get
+// the *_info gauges (always 1) as families of the info type, which client_golang can't produce itself.
+func metricsHandler(g prometheus.Gatherer) http.Handler {
+    plain := promhttp.HandlerFor(g, promhttp.HandlerOpts{EnableOpenMetrics: *openMetrics})
+    if !*openMetrics { return plain }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+        if format.FormatType() != expfmt.TypeOpenMetrics {
+            plain.ServeHTTP(w, r)
+            return
+        }
+        mfs, err := g.Gather()
+        if err != nil {
+            http.Error(w, "Error gathering metrics: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", string(format))
+        var buf bytes.Buffer
+        for _, mf := range mfs {
+            buf.Reset()
+            if _, err := expfmt.MetricFamilyToOpenMetrics(&buf, mf); err != nil {
+                log.Printf("ERROR: Encoding %s: %v", mf.GetName(), err)
+                return
+            }
+            // An info family is named without the suffix its samples carry: # TYPE foo info, foo_info{...} 1
+            out := buf.Bytes()
+            if base, ok := strings.CutSuffix(mf.GetName(), "_info"); ok && mf.GetType() == dto.MetricType_GAUGE {
+                out = bytes.Replace(out, []byte("# HELP "+mf.GetName()+" "), []byte("# HELP "+base+" "), 1)
+                out = bytes.Replace(out, []byte("# TYPE "+mf.GetName()+" gauge\n"), []byte("# TYPE "+base+" info\n"), 1)
+            }
+            w.Write(out)
+        }
+        expfmt.FinalizeOpenMetrics(w)
+    })
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+// Only values taken from the stats read carry its timestamp; scrape-time and inspect values must not
+func TestTimestampedGatherer(t *testing.T) {
+    id := strings.Repeat("d", 64)
+    gatherMetrics(replayClient(t, []replayFrame{testFrame(id, "running", `{}`)}))
+
+    mfs, err := timestampedGatherer(registry).Gather()
+    if err != nil { t.Fatalf("Gather: %v", err) }
+    tests := []struct {
+        metric  string
+        stamped bool
+    }{
+        {"memory_usage_bytes", true},
+        {"memory_limit_bytes", true},
+        {"container_data_age_seconds", false},
+        {"container_cpu_shares", false},
+    }
+    for _, tt := range tests {
+        found := false
+        for _, mf := range mfs {
+            if mf.GetName() != appName+"_"+tt.metric { continue }
+            for _, m := range mf.Metric {
+                for _, lp := range m.Label {
+                    if lp.GetName() != "id" || lp.GetValue() != id[:12] { continue }
+                    found = true
+                    if (m.TimestampMs != nil) != tt.stamped { t.Errorf("%s: timestamped %v, want %v", tt.metric, m.TimestampMs != nil, tt.stamped) }
+                }
+            }
+        }
+        if !found { t.Errorf("%s: no series for the container", tt.metric) }
+    }
+}
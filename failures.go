@@ -0,0 +1,54 @@
+package main
+
+import (
+    "log"
+    "sync"
+    "time"
+
+    "golang.org/x/time/rate"
+)
+
+// Per-container throttle for stats failure logs (-log-throttle)
+type failureLog struct {
+    limiter    *rate.Limiter
+    suppressed int
+    last       time.Time
+}
+
+var (
+    failureLogs  = make(map[string]*failureLog)
+    failureMutex sync.Mutex
+)
+
+// logStatsFailure logs a failed stats call, at most once per -log-throttle per container.
+// Suppressed failures are counted and reported with the next log line.
+func logStatsFailure(cid, name string, err error) {
+    failureMutex.Lock()
+    defer failureMutex.Unlock()
+    f, ok := failureLogs[cid]
+    if !ok {
+        f = &failureLog{limiter: rate.NewLimiter(rate.Every(*logThrottle), 1)}
+        failureLogs[cid] = f
+    }
+    f.last = time.Now()
+    if !f.limiter.Allow() {
+        f.suppressed++
+        return
+    }
+    if f.suppressed > 0 {
+        log.Printf("ERROR: Stats of %s (id: %s): %v (%d more failures since the last report)", name, cid[:12], err, f.suppressed)
+    } else {
+        log.Printf("ERROR: Stats of %s (id: %s): %v", name, cid[:12], err)
+    }
+    f.suppressed = 0
+}
+
+// cleanupFailureLogs drops throttles of containers that stopped failing (or are gone)
+func cleanupFailureLogs() {
+    keep := max(*logThrottle, time.Duration(*interval)*2*time.Second)
+    failureMutex.Lock()
+    defer failureMutex.Unlock()
+    for cid, f := range failureLogs {
+        if time.Since(f.last) > keep { delete(failureLogs, cid) }
+    }
+}
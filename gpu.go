@@ -0,0 +1,109 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "log"
+    "os"
+    "os/exec"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "golang.org/x/time/rate"
+)
+
+// Set in main when -gpu is on and nvidia-smi is available
+var gpuAvailable bool
+
+// A failing nvidia-smi fails every tick, log it once a minute
+var gpuErrorLog = rate.Sometimes{Interval: time.Minute}
+
+// Container ids appear in the cgroup paths of their processes (/docker/<id>, docker-<id>.scope, ...)
+var cgroupContainerID = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// gpuUsage is what nvidia-smi reports for the processes of one container, summed over processes and GPUs
+type gpuUsage struct {
+    memBytes float64
+    smPct    float64
+}
+
+// collectGPU maps the GPU processes reported by nvidia-smi to containers (through /proc/<pid>/cgroup, so it
+// needs the host PID namespace) and exports their GPU memory and SM utilization. Runs once per tick.
+func collectGPU() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    usage := make(map[string]*gpuUsage)
+    add := func(pid string) *gpuUsage {
+        cid := pidContainer(pid)
+        if cid == "" { return nil }
+        if usage[cid] == nil { usage[cid] = &gpuUsage{} }
+        return usage[cid]
+    }
+
+    // pid, used_memory (MiB)
+    out, err := exec.CommandContext(ctx, "nvidia-smi", "--query-compute-apps=pid,used_memory", "--format=csv,noheader,nounits").Output()
+    if err != nil {
+        gpuErrorLog.Do(func() { log.Printf("ERROR: nvidia-smi: %v", err) })
+        return
+    }
+    for _, line := range strings.Split(string(out), "\n") {
+        fields := strings.Split(line, ",")
+        if len(fields) != 2 { continue }
+        mib, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+        if err != nil { continue }
+        if u := add(strings.TrimSpace(fields[0])); u != nil { u.memBytes += mib * 1024 * 1024 }
+    }
+
+    // One sample of per-process utilization: gpu pid type sm mem enc dec command ("-" when idle)
+    if out, err := exec.CommandContext(ctx, "nvidia-smi", "pmon", "-c", "1", "-s", "u").Output(); err == nil {
+        sc := bufio.NewScanner(bytes.NewReader(out))
+        for sc.Scan() {
+            fields := strings.Fields(sc.Text())
+            if len(fields) < 4 || strings.HasPrefix(fields[0], "#") { continue }
+            sm, err := strconv.ParseFloat(fields[3], 64)
+            if err != nil { continue }
+            if u := add(fields[1]); u != nil { u.smPct += sm }
+        }
+    }
+
+    historyMutex.RLock()
+    tracked := make(map[string]prometheus.Labels, len(cpuHistory))
+    for cid, snap := range cpuHistory { tracked[cid] = snap.labels }
+    historyMutex.RUnlock()
+
+    // Only containers using a GPU get series; they disappear once the container stops using it
+    for cid, labels := range tracked {
+        u, ok := usage[cid]
+        if !ok {
+            gpuMemSeries.update(cid, nil)
+            gpuUtilSeries.update(cid, nil)
+            continue
+        }
+        gaugeGpuMem.With(labels).Set(u.memBytes)
+        gaugeGpuUtil.With(labels).Set(scaleRatio(u.smPct))
+        gpuMemSeries.update(cid, []prometheus.Labels{labels})
+        gpuUtilSeries.update(cid, []prometheus.Labels{labels})
+    }
+}
+
+// pidContainer returns the id of the container a host process belongs to ("" if none)
+func pidContainer(pid string) string {
+    raw, err := os.ReadFile("/proc/" + pid + "/cgroup")
+    if err != nil { return "" }
+    return cgroupContainerID.FindString(string(raw))
+}
+
+// containerProcCgroup returns /proc/<pid>/cgroup of a container's main process. The pid comes from cached
+// inspect data (-meta-interval), so after a restart it may be gone or reused by an unrelated process;
+// the file must name the container to be used.
+func containerProcCgroup(pid int, cid string) (string, error) {
+    raw, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cgroup")
+    if err != nil { return "", err }
+    if cgroupContainerID.FindString(string(raw)) != cid { return "", os.ErrNotExist }
+    return string(raw), nil
+}
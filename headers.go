@@ -0,0 +1,42 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+)
+
+// headerList collects repeated -header=Key:Value flags
+type headerList http.Header
+
+// String lists the header names only, values may be credentials
+func (h headerList) String() string {
+    names := make([]string, 0, len(h))
+    for k := range h { names = append(names, k) }
+    sort.Strings(names)
+    return strings.Join(names, ",")
+}
+
+func (h headerList) Set(s string) error {
+    k, v, ok := strings.Cut(s, ":")
+    if !ok || strings.TrimSpace(k) == "" { return fmt.Errorf("expected Key:Value") }
+    http.Header(h).Add(strings.TrimSpace(k), strings.TrimSpace(v))
+    return nil
+}
+
+// headerTransport adds fixed headers (e.g. gateway credentials) to every Docker API request
+type headerTransport struct {
+    next    http.RoundTripper
+    headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    // RoundTrippers must not modify the caller's request
+    req = req.Clone(req.Context())
+    for k, vs := range t.headers {
+        req.Header.Del(k)
+        for _, v := range vs { req.Header.Add(k, v) }
+    }
+    return t.next.RoundTrip(req)
+}
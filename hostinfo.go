@@ -0,0 +1,26 @@
+package main
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/docker/docker/client"
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// registerHostCgroupInfo exposes the cgroup version and driver of the daemon host as an info metric.
+// They explain differences between hosts (e.g. rss is missing on cgroup v2). Read once at startup.
+func registerHostCgroupInfo(cli *client.Client) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    info, err := cli.Info(ctx)
+    cancel()
+    if err != nil {
+        log.Printf("WARN: Info: %v, not exporting host_cgroup_info", err)
+        return
+    }
+
+    gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_host_cgroup_info"}, []string{"version", "driver"})
+    gauge.WithLabelValues(info.CgroupVersion, info.CgroupDriver).Set(1)
+    mustRegister(gauge)
+}
@@ -2,19 +2,27 @@ package main
 
 import (
     "context"
+    "crypto/sha256"
+    "encoding/hex"
     "encoding/json"
     "flag"
     "fmt"
     "log"
+    "math/rand"
     "net/http"
+    "os"
+    "os/exec"
+    "path"
     "strings"
     "sync"
+    "sync/atomic"
     "time"
 
     "github.com/docker/docker/api/types"
+    "github.com/docker/docker/api/types/container"
     "github.com/docker/docker/client"
+    "github.com/docker/docker/errdefs"
     "github.com/prometheus/client_golang/prometheus"
-    "github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
@@ -25,28 +33,109 @@ var (
 )
 
 var (
-    port       = flag.Int("port", 9487, "Port to expose metrics")
-    interval   = flag.Int("interval", 10, "Interval in seconds (min: 3)")
-    hostIP     = flag.String("hostip", "", "Docker host IP (for TCP connection)")
-    hostPort   = flag.Int("hostport", 0, "Docker host port (for TCP connection)")
-    maxWorkers = flag.Int("workers", 10, "Max concurrent API calls")
+    port          = flag.Int("port", 9487, "Port to expose metrics (0 = no HTTP server, e.g. with -output-file)")
+    interval      = flag.Int("interval", 10, "Interval in seconds (min: 3)")
+    metaInterval  = flag.Duration("meta-interval", time.Minute, "How often to refresh inspect-derived configuration per container (0 = every interval)")
+    dockerCtx     = flag.String("context", "", "Docker CLI context to connect with (endpoint and TLS from ~/.docker/contexts, like docker --context)")
+    webCert       = flag.String("web-cert", "", "Serve HTTPS with this certificate file (PEM, together with -web-key)")
+    webKey        = flag.String("web-key", "", "Private key file (PEM) for -web-cert")
+    webClientCA   = flag.String("web-client-ca", "", "Require scrapers to present a client certificate signed by this CA (PEM, needs -web-cert)")
+    hostIP        = flag.String("hostip", "", "Docker host IP (for TCP connection)")
+    hostPort      = flag.Int("hostport", 0, "Docker host port (for TCP connection)")
+    apiPrefix     = flag.String("api-path-prefix", "", "Path prefix the Docker API is served under, e.g. /docker behind a reverse proxy")
+    maxWorkers    = flag.Int("workers", 10, "Max concurrent API calls")
+    netExclude    = flag.String("network-exclude", "", "Comma-separated interfaces (globs allowed) left out of the network counters, e.g. eth1")
+    netMode       = flag.String("network-mode", "counter", "Network metrics as counter (bytes total, use rate()) or gauge (bytes per second since the previous tick)")
+    netWrap32     = flag.Bool("net-wrap32", false, "Treat network counters as 32 bit and count wraps at 4GiB instead of resets")
+    minContainers = flag.Int("min-containers", 0, "Report not ready on /ready while fewer containers are collectable (0 = disabled)")
+    maxContainers = flag.Int("max-containers", 0, "Collect at most this many containers per tick (0 = no limit)")
+    sampleRate    = flag.Float64("sample-rate", 1, "Share of containers collected per tick, rotating over all of them (e.g. 0.1; 1 = all)")
+    aggregateBy   = flag.String("aggregate-by-label", "", "Aggregate container series by the value of this container label, e.g. team (one series per value, summed over its containers)")
+    collapseBy    = flag.String("collapse-by", "none", "Aggregate container series: none, or image (one series per image, summed over its containers)")
+    daemonRPS     = flag.Float64("daemon-rps", 0, "Max Docker API requests per second, whatever the number of workers (0 = unlimited)")
+    stagger       = flag.Duration("stagger", 0, "Average delay between starting the stats calls of a tick, jittered by ±50% (e.g. 5ms; 0 = all at once)")
+    batchSize     = flag.Int("batch-size", 0, "Process containers in sequential batches of this size (0 = all at once)")
+    recordFile    = flag.String("record", "", "Record raw Docker API responses to this file (JSON lines)")
+    replayFile    = flag.String("replay", "", "Replay a file written by -record instead of connecting to Docker")
+    optInLabel    = flag.String("opt-in-label", "", "Only collect containers carrying this label, as key=value or just key (e.g. prometheus.io/scrape=true)")
+    dropIDUnique  = flag.Bool("drop-id-when-unique", false, "Leave the 'id' label empty for containers whose name is unique on the host (ids are kept for colliding names)")
+    stableIDs     = flag.Bool("stable-ids", false, "Use a hash of name, image and compose service as 'id', so recreated containers continue their series")
+    unknownName   = flag.String("unknown-name-strategy", "shortid", "Name of containers without one: shortid (12 character id), id (full id) or literal (\"unknown\")")
+    nameFrom      = flag.String("name-from", "container", "Source of the 'name' label: container (name) or compose-service (com.docker.compose.service label, if set)")
+    envLabel      = flag.String("env-label", "", "Comma-separated container environment variables exported as env_<NAME> labels (e.g. VERSION); never lists all of them")
+    groupLabel    = flag.String("group-label", "", "Container label whose value is exported as a 'group' label (e.g. com.docker.compose.project)")
+    roles         = flag.Bool("roles", false, "Add a 'role' label: pause, init or app (see -pause-images, -init-label)")
+    pauseImages   = flag.String("pause-images", "registry.k8s.io/pause,k8s.gcr.io/pause,rancher/mirrored-pause", "Comma-separated image prefixes of pause (pod sandbox) containers, for -roles")
+    initLabel     = flag.String("init-label", "", "Label (key=value or key) marking init containers, for -roles")
+    sharedNetns   = flag.Bool("shared-netns", false, "Label network counters with shared_netns, the container whose network namespace is joined (\"\" for own namespaces)")
+    networkInfo   = flag.Bool("network-info", false, "Export container network names and IP addresses (high cardinality)")
+    sizes         = flag.Bool("sizes", false, "Export container filesystem sizes (makes the daemon walk every container filesystem on each tick, expensive)")
+    pressure      = flag.Bool("pressure", false, "Export CPU/memory/IO pressure stall information of containers (cgroup v2, local daemons only)")
+    detailedMem   = flag.Bool("detailed-memory", false, "Export mapped file memory and page fault counters")
+    cpuWait       = flag.Bool("cpu-wait", false, "Export time spent waiting for a CPU (incl. steal), read from /proc; local daemons only, needs the host PID namespace")
+    checkpoints   = flag.Bool("checkpoints", false, "Export the number of checkpoints per container (CRIU, needs an experimental daemon)")
+    volumeQuota   = flag.Bool("volume-quota", false, "Export usage and quota of local volumes with a project quota or their own filesystem; local daemons only")
+    gpu           = flag.Bool("gpu", false, "Export per-container NVIDIA GPU memory and utilization (needs nvidia-smi and the host PID namespace)")
+    topEnabled    = flag.Bool("top", false, "Export per-executable process counts (runs 'docker top', expensive)")
+    topInterval   = flag.Duration("top-interval", time.Minute, "Min time between 'docker top' calls per container")
+    watchEvts     = flag.Bool("events", false, "Follow the Docker events stream to pick up new containers between ticks")
+    ratioScale    = flag.String("ratio-scale", "percent", "Unit of the *_ratio metrics: percent (0-100 per core) or fraction (0-1 per core)")
+    crashRestarts = flag.Int("crashloop-restarts", 5, "Restarts within -crashloop-window that set container_crashloop (requires -events)")
+    crashWindow   = flag.Duration("crashloop-window", 10*time.Minute, "Window for -crashloop-restarts")
+    memQuantiles  = flag.String("memory-ratio-quantiles", "0.5,0.9,0.99", "Quantiles of the memory_ratio_summary across containers")
+    omitZeros     = flag.Bool("omit-zeros", false, "Don't export container gauges whose value is zero (series disappear instead)")
+    openMetrics   = flag.Bool("openmetrics", false, "Offer the OpenMetrics format to scrapers asking for it, with info metrics as the info type")
+    timestamps    = flag.Bool("timestamps", false, "Attach Docker's stats read time to container samples (changes Prometheus staleness handling)")
+    scrapeRate    = flag.Float64("scrape-rate-limit", 0, "Max /metrics requests per second, excess gets 429 (0 = unlimited)")
+    cpuSmoothing  = flag.Float64("cpu-smoothing", 0, "Smooth cpu_usage_ratio with an exponential moving average of this weight for the new value, e.g. 0.3 (0 = raw values)")
+    cpuClamp      = flag.Bool("cpu-clamp", false, "Clamp cpu_usage_ratio to [0, online CPUs * 100], counting clamped values in cpu_clamped_total")
+    pressureScore = flag.String("pressure-score", "", "Export container_pressure_score, weighting its components like cpu=1,memory=1,io=1 (empty = disabled)")
+    alertMem      = flag.Float64("alert-mem-ratio", 0, "Set container_alert{reason=\"memory\"} while memory_usage_ratio exceeds this (0 = off)")
+    alertCpu      = flag.Float64("alert-cpu-ratio", 0, "Set container_alert{reason=\"cpu\"} while cpu_usage_ratio exceeds this (0 = off)")
+    pidFile       = flag.String("pidfile", "", "Write the process ID to this file, removed again on SIGINT/SIGTERM")
+    outputFile    = flag.String("output-file", "", "Also write the metrics to this file after every tick, for node_exporter's textfile collector (*.prom)")
+    statsdAddr    = flag.String("statsd", "", "Also push metrics to this StatsD daemon after every tick (host:port, UDP)")
+    statsdTags    = flag.Bool("statsd-tags", false, "Send labels as DogStatsD tags instead of folding them into the metric name")
+    selfTest      = flag.Bool("selftest", false, "Run the metric calculations against synthetic stats, print the result and exit")
+    admin         = flag.Bool("admin", false, "Enable admin endpoints (POST /collect); only expose the port to trusted networks")
+    strictHealth  = flag.Bool("strict-health", false, "Fail /health (503) while the last collection cycle had errors")
+    quiet         = flag.Bool("quiet", false, "Only log warnings and errors")
+    logThrottle   = flag.Duration("log-throttle", time.Minute, "Log repeated stats failures of a container at most this often")
+    debug         = flag.Bool("debug", false, "Enable /debug endpoints (exposes container names and internal state)")
     // Both -v and --version / -version will work
     showVer      = flag.Bool("version", false, "Show version and exit")
     showVerShort = flag.Bool("v", false, "Show version and exit (short)")
 )
 
+// Extra headers for every Docker API request (-header, repeatable)
+var extraHeaders = headerList{}
+
 // Internal storage for CPU deltas (since Docker OneShot stats often have PreCPU=0)
 type cpuSnapshot struct {
     totalUsage  uint64
     systemUsage uint64
-    lastSeen    time.Time
+    lastSeen    time.Time // also refreshed for containers skipped on a tick (sampling, dockerstats.interval)
+    collectedAt time.Time
+    readTime    time.Time
     name        string
+    labels      prometheus.Labels
+    cpuEMA      float64 // last exported CPU percent with -cpu-smoothing
+    hasEMA      bool
 }
 
 // Internal storage for Network deltas (we convert Docker's absolute counters into Prometheus counters)
 type netSnapshot struct {
-    rxBytes uint64
+    rxBytes uint64 // totals over all interfaces
     txBytes uint64
+    ifaces  map[string]netCounters
+
+    lastActive time.Time // last tick the counters increased (or tracking started)
+    at         time.Time // when the counters were read, for -network-mode=gauge
+}
+
+type netCounters struct {
+    rx uint64
+    tx uint64
 }
 
 var (
@@ -55,40 +144,14 @@ var (
 
     netHistory = make(map[string]netSnapshot)
     netMutex   sync.RWMutex
-
-    registry = prometheus.NewRegistry()
-
-    // Metrics Gauges / Counters
-    gaugeCpu        = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_cpu_usage_ratio"}, []string{"name", "id"})
-    gaugeMemBytes   = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_memory_usage_bytes"}, []string{"name", "id"})
-    gaugeMemRss     = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_memory_usage_rss_bytes"}, []string{"name", "id"})
-    gaugeMemLimit   = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_memory_limit_bytes"}, []string{"name", "id"})
-    gaugeMemRatio   = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_memory_usage_ratio"}, []string{"name", "id"})
-    counterNetRx    = prometheus.NewCounterVec(prometheus.CounterOpts{Name: appName + "_network_received_bytes_total"}, []string{"name", "id"})
-    counterNetTx    = prometheus.NewCounterVec(prometheus.CounterOpts{Name: appName + "_network_transmitted_bytes_total"}, []string{"name", "id"})
-    gaugeBlockRead  = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_blockio_read_bytes"}, []string{"name", "id"})
-    gaugeBlockWrite = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_blockio_written_bytes"}, []string{"name", "id"})
 )
 
-func init() {
-    registry.MustRegister(
-        gaugeCpu,
-        gaugeMemBytes,
-        gaugeMemRss,
-        gaugeMemLimit,
-        gaugeMemRatio,
-        counterNetRx,
-        counterNetTx,
-        gaugeBlockRead,
-        gaugeBlockWrite,
-    )
-}
-
 func main() {
     flag.Usage = func() {
         fmt.Fprintf(flag.CommandLine.Output(), "%s (Version: %s)\n\nUsage:\n", fullProgName, version)
         flag.PrintDefaults()
     }
+    flag.Var(extraHeaders, "header", "Extra header sent with every Docker API request, as Key:Value (repeatable, e.g. for authenticating proxies)")
     flag.Parse()
 
     if *showVer || *showVerShort {
@@ -96,26 +159,100 @@ func main() {
         return
     }
     if *interval < 3 { *interval = 3 }
+    switch {
+    case *collapseBy != "none" && *collapseBy != "image":
+        log.Fatalf("FATAL: Invalid -collapse-by %q (expected none or image)", *collapseBy)
+    case *nameFrom != "container" && *nameFrom != "compose-service":
+        log.Fatalf("FATAL: Invalid -name-from %q (expected container or compose-service)", *nameFrom)
+    case *unknownName != "shortid" && *unknownName != "id" && *unknownName != "literal":
+        log.Fatalf("FATAL: Invalid -unknown-name-strategy %q (expected shortid, id or literal)", *unknownName)
+    case *netMode != "counter" && *netMode != "gauge":
+        log.Fatalf("FATAL: Invalid -network-mode %q (expected counter or gauge)", *netMode)
+    case (*webCert == "") != (*webKey == ""):
+        log.Fatalf("FATAL: -web-cert and -web-key must be set together")
+    case *webClientCA != "" && *webCert == "":
+        log.Fatalf("FATAL: -web-client-ca needs HTTPS, set -web-cert and -web-key")
+    case *ratioScale != "percent" && *ratioScale != "fraction":
+        log.Fatalf("FATAL: Invalid -ratio-scale %q (expected percent or fraction)", *ratioScale)
+    case *cpuSmoothing < 0 || *cpuSmoothing >= 1:
+        log.Fatalf("FATAL: Invalid -cpu-smoothing %v (expected 0 <= value < 1)", *cpuSmoothing)
+    case *collapseBy != "none" && *aggregateBy != "":
+        log.Fatalf("FATAL: -collapse-by and -aggregate-by-label can't be combined")
+    case collapsing() && (*topEnabled || *networkInfo || *gpu || *volumeQuota):
+        log.Fatalf("FATAL: -top, -network-info, -gpu and -volume-quota can't be combined with -collapse-by or -aggregate-by-label")
+    }
+    if *pressureScore != "" {
+        var err error
+        if pressureWeights, err = parseScoreWeights(*pressureScore); err != nil { log.Fatalf("FATAL: Invalid -pressure-score: %v", err) }
+    }
+    initMetrics()
+    registerConfigInfo()
 
-    var cli *client.Client
-    var err error
+    if *selfTest {
+        os.Exit(runSelfTest())
+    }
+    var opts []client.Opt
 
     // Connection Logic
-    if *hostIP != "" && *hostPort != 0 {
-        hostAddr := fmt.Sprintf("tcp://%s:%d", *hostIP, *hostPort)
-        log.Printf("INFO: Connecting to Docker on %s...", hostAddr)
-        cli, err = client.NewClientWithOpts(
-            client.WithHost(hostAddr),
-            client.WithAPIVersionNegotiation(),
+    if *replayFile != "" {
+        rt, err := newReplayTransport(*replayFile)
+        if err != nil {
+            log.Fatalf("FATAL: Unable to load replay file: %v", err)
+        }
+        logInfo("Replaying %d recorded frames from %s...", len(rt.frames), *replayFile)
+        opts = append(opts,
+            client.WithHost("tcp://replay"),
+            client.WithHTTPClient(&http.Client{Transport: rt}),
         )
+    } else if *dockerCtx != "" {
+        host, ctxOpts, err := contextOpts(*dockerCtx)
+        if err != nil {
+            log.Fatalf("FATAL: Unable to load Docker context: %v", err)
+        }
+        logInfo("Connecting to Docker on %s (context %s)...", host, *dockerCtx)
+        opts = append(opts, ctxOpts...)
+    } else if *hostIP != "" && *hostPort != 0 {
+        hostAddr := fmt.Sprintf("tcp://%s:%d", *hostIP, *hostPort)
+        logInfo("Connecting to Docker on %s...", hostAddr)
+        opts = append(opts, client.WithHost(hostAddr))
     } else {
-        log.Printf("INFO: Connecting to Docker on default socket (/var/run/docker.sock)...")
-        cli, err = client.NewClientWithOpts(
-            client.FromEnv,
-            client.WithAPIVersionNegotiation(),
-        )
+        logInfo("Connecting to Docker on default socket (/var/run/docker.sock)...")
+        opts = append(opts, client.FromEnv)
+    }
+    opts = append(opts, client.WithAPIVersionNegotiation(), withIdleConns(*maxWorkers))
+
+    opts = append(opts, withTransport(func(next http.RoundTripper) (http.RoundTripper, error) {
+        return &apiCallTransport{next: next}, nil
+    }))
+    if *daemonRPS > 0 {
+        logInfo("Limiting Docker API requests to %v per second", *daemonRPS)
+        opts = append(opts, withTransport(func(next http.RoundTripper) (http.RoundTripper, error) {
+            return newDaemonRateTransport(next, *daemonRPS), nil
+        }))
+    }
+    if strings.Trim(*apiPrefix, "/") != "" {
+        logInfo("Sending Docker API requests below %s", *apiPrefix)
+        opts = append(opts, withTransport(func(next http.RoundTripper) (http.RoundTripper, error) {
+            return newPrefixTransport(next, *apiPrefix), nil
+        }))
+    }
+    if len(extraHeaders) > 0 {
+        logInfo("Sending extra headers with Docker API requests: %s", extraHeaders)
+        opts = append(opts, withTransport(func(next http.RoundTripper) (http.RoundTripper, error) {
+            return &headerTransport{next: next, headers: http.Header(extraHeaders)}, nil
+        }))
+    }
+    if *recordFile != "" {
+        logInfo("Recording Docker API responses to %s", *recordFile)
+        opts = append(opts, withTransport(func(next http.RoundTripper) (http.RoundTripper, error) {
+            rt, err := newRecordTransport(next, *recordFile)
+            if err != nil { return nil, err }
+            recorder = rt
+            return rt, nil
+        }))
     }
 
+    cli, err := client.NewClientWithOpts(opts...)
     if err != nil {
         log.Fatalf("FATAL: Unable to create Docker client: %v", err)
     }
@@ -127,142 +264,755 @@ func main() {
         log.Fatalf("FATAL: Could not connect to Docker: %v", err)
     }
     cancel()
-    log.Printf("INFO: Connection established")
+    logInfo("Connection established")
+    if *replayFile == "" { registerHostCgroupInfo(cli) }
+
+    var statsd *statsdEmitter
+    if *statsdAddr != "" {
+        if statsd, err = newStatsdEmitter(*statsdAddr, *statsdTags); err != nil {
+            log.Fatalf("FATAL: Unable to set up StatsD: %v", err)
+        }
+        logInfo("Pushing metrics to StatsD on %s", *statsdAddr)
+    }
+
+    if *checkpoints { checkpointsAvailable = detectCheckpoints(cli) }
+    if *cpuWait {
+        if strings.HasPrefix(cli.DaemonHost(), "unix://") {
+            cpuWaitAvailable = true
+        } else {
+            logInfo("Docker daemon is not local, CPU wait metrics disabled")
+        }
+    }
+    if *pressure {
+        switch {
+        case !strings.HasPrefix(cli.DaemonHost(), "unix://"):
+            logInfo("Docker daemon is not local, pressure metrics disabled")
+        case !cgroupV2Available():
+            logInfo("Host doesn't use cgroup v2, pressure metrics disabled")
+        default:
+            pressureAvailable = true
+        }
+    }
+    if *volumeQuota {
+        if strings.HasPrefix(cli.DaemonHost(), "unix://") {
+            volumeQuotaAvailable = true
+        } else {
+            logInfo("Docker daemon is not local, volume quota metrics disabled")
+        }
+    }
+    if *gpu {
+        if _, err := exec.LookPath("nvidia-smi"); err != nil {
+            logInfo("nvidia-smi not found, GPU metrics disabled")
+        } else {
+            gpuAvailable = true
+        }
+    }
 
     // Background polling
+    watchPauseSignal()
+    watchShutdown()
     go func() {
         for {
-            gatherMetrics(cli)
-            cleanupHistory()
+            if !pollingPaused.Load() {
+                gatherMetrics(cli)
+                cleanupHistory()
+                updateSeriesCount()
+            }
+            if statsd != nil { statsd.push() }
+            if *outputFile != "" {
+                // Written to a temporary file and renamed, so readers never see a partial file
+                if err := prometheus.WriteToTextfile(*outputFile, registry); err != nil { log.Printf("ERROR: Writing %s: %v", *outputFile, err) }
+            }
             time.Sleep(time.Duration(*interval) * time.Second)
         }
     }()
 
+    if *watchEvts {
+        go watchEvents(cli)
+    }
+    if *replayFile == "" {
+        go watchDockerRoot(cli)
+    }
+
     // Server setup
-    http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
-    http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) })
+    var gatherer prometheus.Gatherer = registry
+    if *timestamps {
+        gatherer = timestampedGatherer(gatherer)
+    }
+    handler := filteredMetrics(gatherer, metricsHandler(gatherer))
+    if *scrapeRate > 0 {
+        handler = rateLimited(handler, *scrapeRate)
+    }
+    http.Handle("/metrics", handler)
+    http.HandleFunc("/health", handleHealth)
+    http.HandleFunc("/ready", handleReady)
+    http.HandleFunc("/health/summary", handleHealthSummary)
+    if *debug {
+        http.HandleFunc("/debug/history", handleDebugHistory)
+        http.HandleFunc("/rawstats", handleRawStats(cli))
+    }
+    if *admin {
+        http.HandleFunc("/collect", handleCollect(cli))
+        http.HandleFunc("/admin/pause", handlePause)
+    }
+
+    // Only written once startup went through, so a failed start (daemon unreachable, port in use, ...) leaves no pidfile
+    ready := func() { if *pidFile != "" { writePidfile(*pidFile) } }
+    if *port == 0 {
+        // File (or StatsD) output only
+        ready()
+        select {}
+    }
 
-    log.Printf("INFO: %s listening on :%d", fullProgName, *port)
-    if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
+    if err := listenAndServe(fmt.Sprintf(":%d", *port), ready); err != nil {
+        shutdown()
         log.Fatalf("ERROR: Server failed: %v", err)
     }
 }
 
+// logInfo logs routine progress, unless -quiet is set (warnings and errors are always logged)
+func logInfo(format string, args ...any) {
+    if *quiet { return }
+    log.Printf("INFO: "+format, args...)
+}
+
+// withTransport wraps the transport the client ended up with (socket or TCP) in another RoundTripper.
+// The client only switches to https when its final transport is an *http.Transport with a TLS config
+// (DOCKER_TLS_VERIFY, TLS contexts), so the scheme is pinned here before that transport gets wrapped.
+func withTransport(wrap func(http.RoundTripper) (http.RoundTripper, error)) client.Opt {
+    return func(c *client.Client) error {
+        hc := c.HTTPClient()
+        inner := hc.Transport
+        rt, err := wrap(inner)
+        if err != nil { return err }
+        if t, ok := inner.(*http.Transport); ok && t.TLSClientConfig != nil {
+            if err := client.WithScheme("https")(c); err != nil { return err }
+        }
+        hc.Transport = rt
+        return client.WithHTTPClient(hc)(c)
+    }
+}
+
+// withIdleConns keeps an idle connection per worker; with the default of 2, concurrent stats calls keep reconnecting
+// (and against a remote daemon, paying TCP/TLS setup on most calls)
+func withIdleConns(workers int) client.Opt {
+    return withTransport(func(next http.RoundTripper) (http.RoundTripper, error) {
+        if t, ok := next.(*http.Transport); ok { t.MaxIdleConnsPerHost = max(workers, http.DefaultMaxIdleConnsPerHost) }
+        return next, nil
+    })
+}
+
+// Serializes gather cycles (polling loop and POST /collect); also guards the per-cycle state below
+var gatherMutex sync.Mutex
+
+// Containers left out by -max-containers on the previous tick (to only warn on changes)
+var lastTruncated int
+
+// Running containers per image on the previous tick, so images without containers lose their series
+var lastImages map[string]int
+
+// Set once the first gather cycle finished; "new container" logs are suppressed until then
+var initialDiscoveryDone atomic.Bool
+
+// Containers found collectable by the last gather cycle, for /ready
+var collectableCount atomic.Int64
+
+// Set when the last gather cycle couldn't list containers or read some stats, for -strict-health
+var lastGatherFailed atomic.Bool
+
+// States reported by the Docker API; always exported so a state dropping to zero is visible
+var containerStates = []string{"created", "running", "paused", "restarting", "removing", "exited", "dead"}
+
 func gatherMetrics(cli *client.Client) {
+    gatherMutex.Lock()
+    defer gatherMutex.Unlock()
+
     ctx := context.Background()
-    containers, err := cli.ContainerList(ctx, types.ContainerListOptions{})
+    start := time.Now()
+    containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Size: *sizes})
     if err != nil {
         log.Printf("ERROR: ContainerList: %v", err)
+        lastGatherFailed.Store(true)
         return
     }
 
+    // Count every container by state, then only keep the ones that can report stats (and opted in, if required)
+    counts := make(map[string]int)
+    for _, st := range containerStates { counts[st] = 0 }
+    images := make(map[string]int)
+    health := &healthSummary{Updated: time.Now()}
+    running := containers[:0]
+    for _, c := range containers {
+        counts[c.State]++
+        if c.State == "running" {
+            images[c.Image]++
+            health.countHealth(c.Status)
+        }
+        histContainerAge.Observe(time.Since(time.Unix(c.Created, 0)).Seconds())
+        if collectable(c) { running = append(running, c) }
+    }
+    for st, n := range counts { gaugeContainers.WithLabelValues(st).Set(float64(n)) }
+    for image, n := range images { gaugeImageContainers.WithLabelValues(image).Set(float64(n)) }
+    for image := range lastImages {
+        if images[image] == 0 { gaugeImageContainers.DeleteLabelValues(image) }
+    }
+    lastImages = images
+    lastHealth.Store(health)
+    containers = running
+    collectableCount.Store(int64(len(containers)))
+    if *minContainers > 0 {
+        below := 0.0
+        if len(containers) < *minContainers { below = 1 }
+        gaugeBelowMin.Set(below)
+    }
+
+    if *dropIDUnique {
+        names := make(map[string]int, len(containers))
+        for _, c := range containers { names[containerName(c)]++ }
+        nameCounts.Store(&names)
+    }
+
+    // Hard cap protecting the exporter on pathologically large hosts
+    if *maxContainers > 0 && len(containers) > *maxContainers {
+        dropped := len(containers) - *maxContainers
+        if dropped != lastTruncated {
+            log.Printf("WARN: %d containers found, only collecting the first %d (-max-containers)", len(containers), *maxContainers)
+        }
+        lastTruncated = dropped
+        counterTruncated.Add(float64(dropped))
+        containers = containers[:*maxContainers]
+    } else {
+        lastTruncated = 0
+    }
+
+    containers = dueContainers(containers)
+    if *sampleRate > 0 && *sampleRate < 1 { containers = sampleContainers(containers) }
+    gaugeSampled.Set(float64(len(containers)))
+
     var wg sync.WaitGroup
-    semaphore := make(chan struct{}, *maxWorkers)
+    var collected atomic.Int64
+    pool := newWorkerPool(*maxWorkers)
 
-    for _, c := range containers {
-        wg.Add(1)
-        go func(cid string, cnames []string) {
-            defer wg.Done()
-            semaphore <- struct{}{}
-            defer func() { <-semaphore }()
-
-            stats, err := cli.ContainerStatsOneShot(ctx, cid)
-            if err != nil { return }
-            defer stats.Body.Close()
-
-            var v types.StatsJSON
-            if err := json.NewDecoder(stats.Body).Decode(&v); err != nil { return }
-
-            name := "unknown"
-            if len(cnames) > 0 { name = strings.TrimPrefix(cnames[0], "/") }
-            labels := prometheus.Labels{"name": name, "id": cid[:12]}
-
-            // --- CPU Calculation (Self-managed Delta) ---
-            currentTotal := v.CPUStats.CPUUsage.TotalUsage
-            currentSystem := v.CPUStats.SystemUsage
-
-            historyMutex.RLock()
-            prev, found := cpuHistory[cid]
-            historyMutex.RUnlock()
-
-            if found {
-                cpuDelta := float64(currentTotal) - float64(prev.totalUsage)
-                systemDelta := float64(currentSystem) - float64(prev.systemUsage)
-                onlineCPUs := float64(v.CPUStats.OnlineCPUs)
-                if onlineCPUs == 0 { onlineCPUs = float64(len(v.CPUStats.CPUUsage.PercpuUsage)) }
-
-                if systemDelta > 0 && cpuDelta > 0 {
-                    cpuPercent := (cpuDelta / systemDelta) * onlineCPUs * 100.0
-                    gaugeCpu.With(labels).Set(cpuPercent)
+    // Containers are processed in sequential batches (all at once by default) to bound goroutine count
+    batch := len(containers)
+    if *batchSize > 0 { batch = *batchSize }
+
+    for off := 0; off < len(containers); off += batch {
+        end := min(off+batch, len(containers))
+        for i, c := range containers[off:end] {
+            // Spread the calls a bit (-stagger, jittered by ±50%) instead of bursting the daemon
+            if *stagger > 0 && i > 0 { time.Sleep(*stagger/2 + time.Duration(rand.Int63n(int64(*stagger)))) }
+            wg.Add(1)
+            go func(c types.Container) {
+                defer wg.Done()
+                pool.acquire()
+                defer pool.release()
+                if collectExclusive(ctx, cli, c) { collected.Add(1) }
+            }(c)
+        }
+        wg.Wait()
+    }
+
+    if collapsing() { collapsed.flush() }
+    if gpuAvailable { collectGPU() }
+
+    // Share of the cycle during which every worker was busy; close to 1 means -workers is the bottleneck
+    gaugeWorkerSaturation.Set(pool.saturation(time.Since(start)))
+
+    // Share of this tick's containers whose stats could be read (1 when there were none)
+    success := 1.0
+    if len(containers) > 0 { success = float64(collected.Load()) / float64(len(containers)) }
+    gaugeScrapeSuccess.Set(success)
+    lastGatherFailed.Store(success < 1)
+
+    // The first cycle discovers everything that is already running; only report it in bulk
+    if !initialDiscoveryDone.Swap(true) {
+        logInfo("Initial discovery: collecting %d containers", len(containers))
+    }
+}
+
+// containerName returns the name used for the "name" label
+func containerName(c types.Container) string {
+    if *nameFrom == "compose-service" {
+        if svc := c.Labels["com.docker.compose.service"]; svc != "" { return svc }
+    }
+    if len(c.Names) > 0 { return strings.TrimPrefix(c.Names[0], "/") }
+    // Nameless containers would otherwise all share one name (-unknown-name-strategy)
+    switch *unknownName {
+    case "id":
+        return c.ID
+    case "literal":
+        return "unknown"
+    }
+    return c.ID[:12]
+}
+
+// Number of collected containers per name on the last tick (-drop-id-when-unique)
+var nameCounts atomic.Pointer[map[string]int]
+
+// nameCollides reports whether more than one container had this name on the last tick
+func nameCollides(name string) bool {
+    counts := nameCounts.Load()
+    return counts != nil && (*counts)[name] > 1
+}
+
+// stableID derives an id from what survives a container being recreated with the same configuration
+// (name, image, compose service), so its series continue instead of starting over (-stable-ids)
+func stableID(c types.Container, name string) string {
+    sum := sha256.Sum256([]byte(name + "\x00" + c.Image + "\x00" + c.Labels["com.docker.compose.service"]))
+    return hex.EncodeToString(sum[:])[:12]
+}
+
+// containerLabels builds the label set shared by all series of a container
+func containerLabels(c types.Container, name string) prometheus.Labels {
+    l := prometheus.Labels{"name": name, "id": c.ID[:12]}
+    if *stableIDs { l["id"] = stableID(c, name) }
+    if *dropIDUnique && !nameCollides(name) { l["id"] = "" }
+    if *collapseBy == "image" { l = prometheus.Labels{"image": c.Image} }
+    if *aggregateBy != "" { l = prometheus.Labels{labelName(*aggregateBy): c.Labels[*aggregateBy]} }
+    if *groupLabel != "" {
+        // Containers without the label form a group of their own
+        group, ok := c.Labels[*groupLabel]
+        if !ok { group = name }
+        l["group"] = group
+    }
+    if *roles { l["role"] = containerRole(c) }
+    for _, key := range envLabels { l[envLabelName(key)] = cachedEnv(c.ID, key) }
+    return l
+}
+
+// setGauge sets a container gauge, or drops the series altogether when the value is zero and -omit-zeros is set
+func setGauge(vec *prometheus.GaugeVec, labels prometheus.Labels, value float64) {
+    if value == 0 && *omitZeros {
+        vec.Delete(labels)
+        return
+    }
+    vec.With(labels).Set(value)
+}
+
+// scaleRatio converts a percentage into the unit chosen with -ratio-scale
+func scaleRatio(pct float64) float64 {
+    if *ratioScale == "fraction" { return pct / 100 }
+    return pct
+}
+
+// setAlert flags a container whose value exceeds an (optional) threshold, see -alert-* flags
+func setAlert(labels prometheus.Labels, cid, reason string, value, threshold float64) {
+    if threshold <= 0 { return }
+    alert := 0.0
+    if value > threshold { alert = 1 }
+    setAggregated(gaugeAlert, extendLabels(labels, "reason", reason), cid, alert, aggMax)
+}
+
+// collectable reports whether stats should be collected for a listed container
+func collectable(c types.Container) bool {
+    return (c.State == "running" || c.State == "paused") && optedIn(c.Labels) && !disabledByLabel(c.Labels)
+}
+
+// optedIn reports whether a container carries the -opt-in-label (always true when the flag is unset)
+func optedIn(labels map[string]string) bool {
+    if *optInLabel == "" { return true }
+    return hasLabel(labels, *optInLabel)
+}
+
+// hasLabel matches container labels against a key=value or bare key selector
+func hasLabel(labels map[string]string, selector string) bool {
+    key, want, hasValue := strings.Cut(selector, "=")
+    got, ok := labels[key]
+    return ok && (!hasValue || got == want)
+}
+
+// containerRole tells infrastructure containers (pod sandboxes, init containers) apart from the application (-roles)
+func containerRole(c types.Container) string {
+    if hasLabel(c.Labels, "io.kubernetes.docker.type=podsandbox") { return "pause" }
+    for _, prefix := range strings.Split(*pauseImages, ",") {
+        if prefix = strings.TrimSpace(prefix); prefix != "" && strings.HasPrefix(c.Image, prefix) { return "pause" }
+    }
+    if *initLabel != "" && hasLabel(c.Labels, *initLabel) { return "init" }
+    return "app"
+}
+
+// Containers currently being collected (regular tick or event-triggered)
+var (
+    inFlight      = make(map[string]bool)
+    inFlightMutex sync.Mutex
+)
+
+// Start times of the stats calls currently waiting for the daemon, see oldestCallAge
+var (
+    pendingCalls      = make(map[string]time.Time)
+    pendingCallsMutex sync.Mutex
+)
+
+func callStarted(cid string) {
+    pendingCallsMutex.Lock()
+    pendingCalls[cid] = time.Now()
+    pendingCallsMutex.Unlock()
+}
+
+func callDone(cid string) {
+    pendingCallsMutex.Lock()
+    delete(pendingCalls, cid)
+    pendingCallsMutex.Unlock()
+}
+
+// oldestCallAge returns how long the oldest pending stats call has been running (0 when none)
+func oldestCallAge() float64 {
+    pendingCallsMutex.Lock()
+    defer pendingCallsMutex.Unlock()
+    var oldest time.Duration
+    for _, t := range pendingCalls { oldest = max(oldest, time.Since(t)) }
+    return oldest.Seconds()
+}
+
+// collectExclusive runs collectContainer unless the same container is already being collected,
+// so a tick and an event-triggered collection never race on its history entries. Reports whether
+// the stats could be read; a collection already in flight counts as successful.
+func collectExclusive(ctx context.Context, cli *client.Client, c types.Container) bool {
+    cid := c.ID
+    inFlightMutex.Lock()
+    if inFlight[cid] {
+        inFlightMutex.Unlock()
+        return true
+    }
+    inFlight[cid] = true
+    inFlightMutex.Unlock()
+
+    defer func() {
+        inFlightMutex.Lock()
+        delete(inFlight, cid)
+        inFlightMutex.Unlock()
+    }()
+    return collectContainer(ctx, cli, c)
+}
+
+// Cached inspect results: configuration rarely changes, so it's only refreshed every -meta-interval
+type inspectEntry struct {
+    info types.ContainerJSON
+    at   time.Time
+}
+
+var (
+    inspectCache = make(map[string]inspectEntry)
+    inspectMutex sync.Mutex
+)
+
+func inspectContainer(ctx context.Context, cli *client.Client, cid string) (types.ContainerJSON, error) {
+    inspectMutex.Lock()
+    e, ok := inspectCache[cid]
+    inspectMutex.Unlock()
+    if ok && time.Since(e.at) < *metaInterval { return e.info, nil }
+
+    info, err := cli.ContainerInspect(ctx, cid)
+    if err != nil { return info, err }
+
+    inspectMutex.Lock()
+    inspectCache[cid] = inspectEntry{info: info, at: time.Now()}
+    inspectMutex.Unlock()
+    return info, nil
+}
+
+// cachedEnv returns the value of an environment variable from the cached inspect result ("" if unset or not inspected yet)
+func cachedEnv(cid, key string) string {
+    inspectMutex.Lock()
+    e, ok := inspectCache[cid]
+    inspectMutex.Unlock()
+    if !ok || e.info.Config == nil { return "" }
+    for _, kv := range e.info.Config.Env {
+        if k, v, found := strings.Cut(kv, "="); found && k == key { return sanitizeLabelValue(v) }
+    }
+    return ""
+}
+
+// netExcluded reports whether an interface is left out of the network totals (-network-exclude, globs allowed)
+func netExcluded(ifname string) bool {
+    if *netExclude == "" { return false }
+    for _, pattern := range strings.Split(*netExclude, ",") {
+        if ok, _ := path.Match(strings.TrimSpace(pattern), ifname); ok { return true }
+    }
+    return false
+}
+
+// seccompConfined reports whether a seccomp profile applies (the default one unless disabled or privileged)
+func seccompConfined(hc *container.HostConfig) bool {
+    if hc.Privileged { return false }
+    for _, opt := range hc.SecurityOpt {
+        // seccomp=unconfined, or the deprecated seccomp:unconfined
+        if opt == "seccomp=unconfined" || opt == "seccomp:unconfined" { return false }
+    }
+    return true
+}
+
+// boolValue turns a condition into a 0/1 gauge value
+func boolValue(b bool) float64 {
+    if b { return 1 }
+    return 0
+}
+
+// netnsOwner returns the container whose network namespace a container joined (--network=container:<x>),
+// as short id or name, or "" when it has its own
+func netnsOwner(info types.ContainerJSON, infoErr error) string {
+    if infoErr != nil || info.HostConfig == nil || !info.HostConfig.NetworkMode.IsContainer() { return "" }
+    owner := info.HostConfig.NetworkMode.ConnectedContainer()
+    if len(owner) == 64 { owner = owner[:12] }
+    return owner
+}
+
+// collectContainer fetches the stats (and configuration) of a single container and updates its metrics.
+// Returns false when the stats couldn't be read; a container removed in the meantime isn't a failure.
+func collectContainer(ctx context.Context, cli *client.Client, c types.Container) bool {
+    cid := c.ID
+    callStarted(cid)
+    stats, err := cli.ContainerStatsOneShot(ctx, cid)
+    callDone(cid)
+    if err != nil {
+        // The container going away between list and stats is an expected race, anything else is not
+        if errdefs.IsNotFound(err) {
+            counterStatsNotFound.Inc()
+            return true
+        }
+        counterStatsTransportErr.Inc()
+        logStatsFailure(cid, containerName(c), err)
+        return false
+    }
+    defer stats.Body.Close()
+
+    var v types.StatsJSON
+    if err := json.NewDecoder(stats.Body).Decode(&v); err != nil {
+        logStatsFailure(cid, containerName(c), err)
+        return false
+    }
+
+    name := containerName(c)
+    // Inspect before building the labels, -env-label values come from its (cached) result
+    info, infoErr := inspectContainer(ctx, cli, cid)
+    labels := containerLabels(c, name)
+
+    // The listing is fresh every tick, unlike the (cached) inspect data
+    paused := c.State == "paused"
+
+    // --- Configuration (inspect) ---
+    if infoErr == nil {
+        if info.HostConfig != nil {
+            // 0 means "not set", which the kernel treats as the default weight of 1024
+            shares := info.HostConfig.CPUShares
+            if shares == 0 { shares = 1024 }
+            setGauge(gaugeCpuShares, labels, float64(shares))
+
+            // Unset reservation is 0; unset swappiness is reported as -1 (inherit host setting) like the Docker API does
+            setGauge(gaugeMemReservation, labels, float64(info.HostConfig.MemoryReservation))
+            swappiness := int64(-1)
+            if info.HostConfig.MemorySwappiness != nil { swappiness = *info.HostConfig.MemorySwappiness }
+            setGauge(gaugeMemSwappiness, labels, float64(swappiness))
+            setGauge(gaugeOomScoreAdj, labels, float64(info.HostConfig.OomScoreAdj))
+
+            // Per-container settings, tracked per container id: with collapsed series they would flip between
+            // the containers sharing a series and outlive removed ones, so they are left out then
+            if !collapsing() {
+                // One series per configured limit and kind; none for containers relying on the daemon defaults
+                var series []prometheus.Labels
+                for _, u := range info.HostConfig.Ulimits {
+                    if u == nil { continue }
+                    soft := extendLabels(labels, "ulimit", u.Name, "kind", "soft")
+                    hard := extendLabels(labels, "ulimit", u.Name, "kind", "hard")
+                    gaugeUlimit.With(soft).Set(float64(u.Soft))
+                    gaugeUlimit.With(hard).Set(float64(u.Hard))
+                    series = append(series, soft, hard)
+                }
+                ulimitSeries.update(cid, series)
+
+                // Only containers placed below a custom parent (e.g. a systemd slice) get a series
+                series = nil
+                if parent := info.HostConfig.CgroupParent; parent != "" {
+                    l := extendLabels(labels, "cgroup_parent", parent)
+                    gaugeCgroupParent.With(l).Set(1)
+                    series = append(series, l)
                 }
-            } else {
-                log.Printf("INFO: New container detected: %s (id: %s)", name, cid[:12])
+                cgroupParentSeries.update(cid, series)
             }
 
-            // Save state for next tick
-            historyMutex.Lock()
-            cpuHistory[cid] = cpuSnapshot{
-                totalUsage:  currentTotal,
-                systemUsage: currentSystem,
-                lastSeen:    time.Now(),
-                name:        name,
+            // 1 when the profile confines the container, 0 when it runs unconfined; AppArmor only on hosts using it
+            setGauge(gaugeSecProfile, extendLabels(labels, "profile", "seccomp"), boolValue(seccompConfined(info.HostConfig)))
+            if info.AppArmorProfile != "" {
+                setGauge(gaugeSecProfile, extendLabels(labels, "profile", "apparmor"), boolValue(info.AppArmorProfile != "unconfined"))
             }
-            historyMutex.Unlock()
-
-            // --- Memory ---
-            memUsage := float64(v.MemoryStats.Usage)
-            memLimit := float64(v.MemoryStats.Limit)
-            gaugeMemBytes.With(labels).Set(memUsage)
-            gaugeMemLimit.With(labels).Set(memLimit)
-            if memLimit > 0 { gaugeMemRatio.With(labels).Set((memUsage / memLimit) * 100.0) }
-            if rss, ok := v.MemoryStats.Stats["rss"]; ok { gaugeMemRss.With(labels).Set(float64(rss)) }
-
-            // --- Network (cumulative over all interfaces, exported as Prometheus counters) ---
-            var totalRx, totalTx uint64
-            for _, ns := range v.Networks {
-                totalRx += ns.RxBytes
-                totalTx += ns.TxBytes
+        }
+        if *networkInfo && info.NetworkSettings != nil {
+            // One series per attached network
+            var series []prometheus.Labels
+            for netName, ep := range info.NetworkSettings.Networks {
+                if ep == nil { continue }
+                l := extendLabels(labels, "network", netName, "ip", ep.IPAddress)
+                gaugeNetworkInfo.With(l).Set(1)
+                series = append(series, l)
             }
+            networkInfoSeries.update(cid, series)
+        }
+    }
 
-            netMutex.Lock()
-            prevNet, ok := netHistory[cid]
-            if ok {
-                // Convert Docker's absolute counters into Prometheus counter increments.
-                // Handle possible resets (e.g. container restart or network namespace change)
-                // by ignoring negative deltas and just resetting our baseline.
-                if totalRx >= prevNet.rxBytes {
-                    deltaRx := totalRx - prevNet.rxBytes
-                    if deltaRx > 0 {
-                        counterNetRx.With(labels).Add(float64(deltaRx))
-                    }
-                }
-                if totalTx >= prevNet.txBytes {
-                    deltaTx := totalTx - prevNet.txBytes
-                    if deltaTx > 0 {
-                        counterNetTx.With(labels).Add(float64(deltaTx))
-                    }
-                }
+    if *topEnabled { collectTop(ctx, cli, cid, labels) }
+    if checkpointsAvailable { collectCheckpoints(ctx, cli, cid, labels) }
+    if cpuWaitAvailable && infoErr == nil && info.State != nil { collectCPUWait(cid, info.State.Pid, labels) }
+    // Components of container_pressure_score, filled in along the way
+    var score scoreParts
+    if pressureAvailable && infoErr == nil && info.State != nil { score.io, score.hasIO = collectPressure(cid, info.State.Pid, labels) }
+    if volumeQuotaAvailable && infoErr == nil { collectVolumeQuota(cid, info.Mounts, labels) }
+    // Re-evaluated every tick, so the flag clears once the restarts leave the window
+    if *watchEvts { setAggregated(gaugeCrashloop, labels, cid, crashLooping(cid), aggMax) }
+
+    // Host ports, from the listing; a port bound on both IPv4 and IPv6 is listed twice but counts once
+    published := make(map[string]bool)
+    for _, p := range c.Ports {
+        if p.PublicPort != 0 { published[fmt.Sprintf("%d/%s", p.PublicPort, p.Type)] = true }
+    }
+    setAggregated(gaugePublishedPorts, labels, cid, float64(len(published)), aggSum)
+
+    onlineCPUs := float64(v.CPUStats.OnlineCPUs)
+    if onlineCPUs == 0 { onlineCPUs = float64(len(v.CPUStats.CPUUsage.PercpuUsage)) }
+    if infoErr == nil && info.HostConfig != nil {
+        hc := info.HostConfig
+        setAggregated(gaugeCPULimit, labels, cid, calcCPULimit(hc.NanoCPUs, hc.CPUQuota, hc.CPUPeriod, hc.CpusetCpus, onlineCPUs), aggSum)
+    }
+
+    // Filesystem sizes (only filled in by the daemon with -sizes)
+    if *sizes {
+        setAggregated(gaugeRwSize, labels, cid, float64(c.SizeRw), aggSum)
+        setAggregated(gaugeRootFsSize, labels, cid, float64(c.SizeRootFs), aggSum)
+    }
+
+    // --- CPU Calculation (Self-managed Delta) ---
+    cur := cpuSnapshot{
+        totalUsage:  v.CPUStats.CPUUsage.TotalUsage,
+        systemUsage: v.CPUStats.SystemUsage,
+        lastSeen:    time.Now(),
+        collectedAt: time.Now(),
+        readTime:    v.Read,
+        name:        name,
+        labels:      labels,
+    }
+
+    historyMutex.RLock()
+    prev, found := cpuHistory[cid]
+    // Labels change when inspect-derived values (-env-label) only became available later; drop the old series
+    relabeled := found && labelsKey(prev.labels) != labelsKey(labels) && !labelsShared(cid, prev.labels)
+    historyMutex.RUnlock()
+    if relabeled {
+        for _, vec := range containerMetrics { vec.DeletePartialMatch(prev.labels) }
+    }
+
+    // Carried over when this tick yields no value; paused containers restart from their real usage
+    if found && !paused { cur.cpuEMA, cur.hasEMA = prev.cpuEMA, prev.hasEMA }
+
+    if found && paused {
+        // A frozen cgroup doesn't consume CPU, report it explicitly instead of keeping the last value
+        setAggregated(gaugeCpu, labels, cid, 0, aggSum)
+        setAlert(labels, cid, "cpu", 0, *alertCpu)
+    } else if found {
+        if cpuPercent, ok := calcCPUPercent(cur, prev, onlineCPUs); ok {
+            if *cpuClamp {
+                var clamped bool
+                if cpuPercent, clamped = clampCPUPercent(cpuPercent, onlineCPUs); clamped { counterCPUClamped.Inc() }
             }
-            netHistory[cid] = netSnapshot{
-                rxBytes: totalRx,
-                txBytes: totalTx,
+            if *cpuSmoothing > 0 {
+                if cur.hasEMA { cpuPercent = calcEMA(cpuPercent, cur.cpuEMA, *cpuSmoothing) }
+                cur.cpuEMA, cur.hasEMA = cpuPercent, true
             }
-            netMutex.Unlock()
+            setAggregated(gaugeCpu, labels, cid, scaleRatio(cpuPercent), aggSum)
+            setAlert(labels, cid, "cpu", cpuPercent, *alertCpu)
+            // Share of the CPUs the container sees, so a busy single-threaded process isn't "100" on a 16 core host
+            if onlineCPUs > 0 { score.cpu, score.hasCPU = min(cpuPercent/onlineCPUs, 100), true }
+        }
+    } else if initialDiscoveryDone.Load() {
+        logInfo("New container detected: %s (id: %s)", name, cid[:12])
+    }
 
-            // --- Block IO ---
-            var r, w uint64
-            for _, bio := range v.BlkioStats.IoServiceBytesRecursive {
-                switch strings.ToLower(bio.Op) {
-                case "read": r += bio.Value
-                case "write": w += bio.Value
-                }
+    // Save state for next tick
+    historyMutex.Lock()
+    cpuHistory[cid] = cur
+    historyMutex.Unlock()
+
+    // --- Memory ---
+    memUsage := float64(v.MemoryStats.Usage)
+    memLimit := float64(v.MemoryStats.Limit)
+    setAggregated(gaugeMemBytes, labels, cid, memUsage, aggSum)
+    setAggregated(gaugeMemLimit, labels, cid, memLimit, aggSum)
+    if memLimit > 0 {
+        memRatio := (memUsage / memLimit) * 100.0
+        setAggregated(gaugeMemRatio, labels, cid, scaleRatio(memRatio), aggMax)
+        setAlert(labels, cid, "memory", memRatio, *alertMem)
+        summaryMemRatio.Observe(scaleRatio(memRatio))
+        score.memory, score.hasMemory = min(memRatio, 100), true
+    }
+    if *pressureScore != "" {
+        if v, ok := pressureWeights.score(score); ok { setAggregated(gaugePressureScore, labels, cid, scaleRatio(v), aggMax) }
+    }
+    if rss, ok := v.MemoryStats.Stats["rss"]; ok { setAggregated(gaugeMemRss, labels, cid, float64(rss), aggSum) }
+    if *detailedMem { collectDetailedMemory(cid, v.MemoryStats.Stats, labels) }
+
+    // Network and block counters are frozen (or reported empty) while paused. Keep the
+    // pre-pause baselines untouched so resuming isn't mistaken for a counter reset.
+    if paused { return true }
+
+    // --- Network (cumulative over all interfaces, exported as Prometheus counters) ---
+    curNet := netSnapshot{ifaces: make(map[string]netCounters, len(v.Networks)), lastActive: time.Now(), at: v.Read}
+    if curNet.at.IsZero() { curNet.at = time.Now() }
+    for ifname, ns := range v.Networks {
+        if netExcluded(ifname) { continue }
+        curNet.ifaces[ifname] = netCounters{rx: ns.RxBytes, tx: ns.TxBytes}
+        curNet.rxBytes += ns.RxBytes
+        curNet.txBytes += ns.TxBytes
+    }
+
+    netMutex.Lock()
+    prevNet, ok := netHistory[cid]
+    if ok {
+        // Convert Docker's absolute per-interface counters into Prometheus counter increments.
+        // Interfaces that reset or just appeared only set a new baseline, see calcNetDelta.
+        var deltaRx, deltaTx uint64
+        for ifname, c := range curNet.ifaces {
+            p, seen := prevNet.ifaces[ifname]
+            if !seen { continue }
+            if d, ok := calcNetDelta(c.rx, p.rx, *netWrap32); ok { deltaRx += d }
+            if d, ok := calcNetDelta(c.tx, p.tx, *netWrap32); ok { deltaTx += d }
+        }
+        netLabels := labels
+        if *sharedNetns { netLabels = extendLabels(labels, "shared_netns", netnsOwner(info, infoErr)) }
+        if *netMode == "gauge" {
+            // Averaged since the previous reading, which may be several ticks ago (sampling, dockerstats.interval)
+            if secs := curNet.at.Sub(prevNet.at).Seconds(); secs > 0 {
+                setAggregated(gaugeNetRxRate, netLabels, cid, float64(deltaRx)/secs, aggSum)
+                setAggregated(gaugeNetTxRate, netLabels, cid, float64(deltaTx)/secs, aggSum)
             }
-            gaugeBlockRead.With(labels).Set(float64(r))
-            gaugeBlockWrite.With(labels).Set(float64(w))
+        } else {
+            if deltaRx > 0 { counterNetRx.With(netLabels).Add(float64(deltaRx)) }
+            if deltaTx > 0 { counterNetTx.With(netLabels).Add(float64(deltaTx)) }
+        }
+        if deltaRx == 0 && deltaTx == 0 { curNet.lastActive = prevNet.lastActive }
+    }
+    netHistory[cid] = curNet
+    netMutex.Unlock()
+    setAggregated(gaugeNetIdle, labels, cid, time.Since(curNet.lastActive).Seconds(), aggMax)
 
-        }(c.ID, c.Names)
+    // --- Block IO ---
+    var r, w uint64
+    for _, bio := range v.BlkioStats.IoServiceBytesRecursive {
+        switch strings.ToLower(bio.Op) {
+        case "read": r += bio.Value
+        case "write": w += bio.Value
+        }
+    }
+    setAggregated(gaugeBlockRead, labels, cid, float64(r), aggSum)
+    setAggregated(gaugeBlockWrite, labels, cid, float64(w), aggSum)
+    return true
+}
+
+// labelsShared reports whether another tracked container exports the same label set; caller holds historyMutex
+func labelsShared(id string, labels prometheus.Labels) bool {
+    key := labelsKey(labels)
+    for other, snap := range cpuHistory {
+        if other != id && labelsKey(snap.labels) == key { return true }
     }
-    wg.Wait()
+    return false
 }
 
 func cleanupHistory() {
@@ -270,24 +1020,45 @@ func cleanupHistory() {
     defer historyMutex.Unlock()
     for id, snap := range cpuHistory {
         if time.Since(snap.lastSeen) > time.Duration(*interval)*2*time.Second {
-            log.Printf("INFO: Container gone: %s (id: %s). Removing from tracking.", snap.name, id[:12])
-
-            // Clean up Prometheus metrics so they don't stay in /metrics forever
-            l := prometheus.Labels{"name": snap.name, "id": id[:12]}
-            gaugeCpu.Delete(l)
-            gaugeMemBytes.Delete(l)
-            gaugeMemRss.Delete(l)
-            gaugeMemLimit.Delete(l)
-            gaugeMemRatio.Delete(l)
-            counterNetRx.Delete(l)
-            counterNetTx.Delete(l)
-            gaugeBlockRead.Delete(l)
-            gaugeBlockWrite.Delete(l)
+            logInfo("Container gone: %s (id: %s). Removing from tracking.", snap.name, id[:12])
+
+            // Clean up Prometheus metrics so they don't stay in /metrics forever,
+            // unless other containers still share the series (-collapse-by, -aggregate-by-label)
+            if !labelsShared(id, snap.labels) {
+                for _, vec := range containerMetrics { vec.DeletePartialMatch(snap.labels) }
+            }
+            collapsed.forget(id)
 
             delete(cpuHistory, id)
             netMutex.Lock()
             delete(netHistory, id)
             netMutex.Unlock()
+            topMutex.Lock()
+            delete(topHistory, id)
+            topMutex.Unlock()
+            checkpointMutex.Lock()
+            delete(checkpointHistory, id)
+            checkpointMutex.Unlock()
+            cpuWaitMutex.Lock()
+            delete(cpuWaitHistory, id)
+            cpuWaitMutex.Unlock()
+            lastCollectedMutex.Lock()
+            delete(lastCollected, id)
+            lastCollectedMutex.Unlock()
+            faultMutex.Lock()
+            delete(faultHistory, id)
+            faultMutex.Unlock()
+            networkInfoSeries.forget(id)
+            ulimitSeries.forget(id)
+            cgroupParentSeries.forget(id)
+            gpuMemSeries.forget(id)
+            gpuUtilSeries.forget(id)
+            volumeUsedSeries.forget(id)
+            volumeQuotaSeries.forget(id)
+            inspectMutex.Lock()
+            delete(inspectCache, id)
+            inspectMutex.Unlock()
         }
     }
+    cleanupFailureLogs()
 }
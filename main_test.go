@@ -0,0 +1,242 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "runtime"
+    "runtime/metrics"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/docker/docker/api/types"
+    "github.com/docker/docker/client"
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMain(m *testing.M) {
+    *quiet = true
+    initMetrics()
+    os.Exit(m.Run())
+}
+
+// replayClient returns a client answering from the given frames, one frame per gatherMetrics call
+func replayClient(t testing.TB, frames []replayFrame) *client.Client {
+    t.Helper()
+    cli, err := client.NewClientWithOpts(
+        client.WithHost("tcp://test"),
+        client.WithHTTPClient(&http.Client{Transport: &replayTransport{frames: frames, pos: -1}}),
+        client.WithAPIVersionNegotiation(),
+    )
+    if err != nil { t.Fatalf("creating client: %v", err) }
+    return cli
+}
+
+// testFrame is one tick of a single container in the given state; networks is the raw "networks" object
+func testFrame(id, state, networks string) replayFrame {
+    return replayFrame{
+        Containers: json.RawMessage(fmt.Sprintf(`[{"Id": %q, "Names": ["/%s"], "State": %q}]`, id, id[:12], state)),
+        Stats: map[string]json.RawMessage{id: json.RawMessage(`{
+            "read": "2025-01-01T00:00:00Z",
+            "cpu_stats": {"cpu_usage": {"total_usage": 1000000000}, "system_cpu_usage": 10000000000, "online_cpus": 2},
+            "memory_stats": {"usage": 1048576, "limit": 1073741824},
+            "networks": ` + networks + `
+        }`)},
+        Inspect: map[string]json.RawMessage{id: json.RawMessage(`{"Id": "` + id + `", "State": {"Running": true}, "HostConfig": {}}`)},
+    }
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// A wrapped TLS transport must still talk https to the daemon (DOCKER_TLS_VERIFY, TLS contexts)
+func TestWithTransportKeepsTLS(t *testing.T) {
+    srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Api-Version", "1.43")
+        w.Write([]byte("OK"))
+    }))
+    defer srv.Close()
+
+    wrapped := 0
+    cli, err := client.NewClientWithOpts(
+        client.WithHost("tcp://"+srv.Listener.Addr().String()),
+        client.WithHTTPClient(srv.Client()),
+        withTransport(func(next http.RoundTripper) (http.RoundTripper, error) {
+            return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+                wrapped++
+                return next.RoundTrip(req)
+            }), nil
+        }),
+    )
+    if err != nil { t.Fatalf("NewClientWithOpts: %v", err) }
+    if _, err := cli.Ping(context.Background()); err != nil { t.Fatalf("Ping: %v", err) }
+    if wrapped == 0 { t.Errorf("request did not go through the wrapping transport") }
+}
+
+// Counters frozen (or reported empty) while a container is paused must neither reset nor double count on unpause
+func TestPausedNetworkCounterContinuity(t *testing.T) {
+    eth0 := func(rx, tx int) string { return fmt.Sprintf(`{"eth0": {"rx_bytes": %d, "tx_bytes": %d}}`, rx, tx) }
+    tests := []struct {
+        name   string
+        paused string
+    }{
+        {"frozen counters", eth0(1000, 500)},
+        {"no networks", `{}`},
+        {"networks missing", `null`},
+    }
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            id := strings.Repeat(fmt.Sprint(i+1), 64)
+            cli := replayClient(t, []replayFrame{
+                testFrame(id, "running", eth0(1000, 500)),
+                testFrame(id, "paused", tt.paused),
+                testFrame(id, "running", eth0(1800, 700)),
+            })
+
+            for tick := 0; tick < 3; tick++ {
+                gatherMetrics(cli)
+                rx, _ := selfTestValue(appName+"_network_received_bytes_total", prometheus.Labels{"id": id[:12]})
+                tx, _ := selfTestValue(appName+"_network_transmitted_bytes_total", prometheus.Labels{"id": id[:12]})
+                wantRx, wantTx := 0.0, 0.0
+                if tick == 2 { wantRx, wantTx = 800, 200 }
+                if rx != wantRx || tx != wantTx { t.Errorf("tick %d: rx %v, tx %v, want %v, %v", tick+1, rx, tx, wantRx, wantTx) }
+            }
+        })
+    }
+}
+
+// Nameless containers must not collapse into one series unless asked to with -unknown-name-strategy=literal
+func TestContainerNameNameless(t *testing.T) {
+    a, b := types.Container{ID: strings.Repeat("a", 64)}, types.Container{ID: strings.Repeat("b", 64)}
+    tests := []struct {
+        strategy string
+        wantA    string
+        distinct bool
+    }{
+        {"shortid", strings.Repeat("a", 12), true},
+        {"id", strings.Repeat("a", 64), true},
+        {"literal", "unknown", false},
+    }
+    defer func(prev string) { *unknownName = prev }(*unknownName)
+    for _, tt := range tests {
+        t.Run(tt.strategy, func(t *testing.T) {
+            *unknownName = tt.strategy
+            nameA, nameB := containerName(a), containerName(b)
+            if nameA != tt.wantA { t.Errorf("got %q, want %q", nameA, tt.wantA) }
+            if (nameA != nameB) != tt.distinct { t.Errorf("names %q and %q, want distinct: %v", nameA, nameB, tt.distinct) }
+        })
+    }
+
+    // Named containers keep their name whatever the strategy
+    *unknownName = "literal"
+    if got := containerName(types.Container{ID: a.ID, Names: []string{"/web"}}); got != "web" { t.Errorf("named container: got %q, want web", got) }
+}
+
+// manyContainers is one tick of n running containers, with ids derived from base so benchmarks don't share history
+func manyContainers(n int, base string) replayFrame {
+    fr := replayFrame{Stats: make(map[string]json.RawMessage, n), Inspect: make(map[string]json.RawMessage, n)}
+    list := make([]string, 0, n)
+    for i := 0; i < n; i++ {
+        id := fmt.Sprintf("%s%0*d", base, 64-len(base), i)
+        one := testFrame(id, "running", `{"eth0": {"rx_bytes": 1000, "tx_bytes": 500}}`)
+        list = append(list, strings.Trim(string(one.Containers), "[]"))
+        fr.Stats[id], fr.Inspect[id] = one.Stats[id], one.Inspect[id]
+    }
+    fr.Containers = json.RawMessage("[" + strings.Join(list, ",") + "]")
+    return fr
+}
+
+// peakDuring runs f while sampling the goroutine count and the stack memory in use, returning their peaks
+func peakDuring(f func()) (goroutines int, stackBytes uint64) {
+    sample := []metrics.Sample{{Name: "/memory/classes/heap/stacks:bytes"}}
+    done := make(chan struct{})
+    sampled := make(chan struct{})
+    go func() {
+        defer close(sampled)
+        for {
+            goroutines = max(goroutines, runtime.NumGoroutine())
+            metrics.Read(sample)
+            stackBytes = max(stackBytes, sample[0].Value.Uint64())
+            select {
+            case <-done:
+                return
+            case <-time.After(50 * time.Microsecond):
+            }
+        }
+    }()
+    f()
+    close(done)
+    <-sampled
+    return goroutines, stackBytes
+}
+
+// -batch-size bounds the goroutines (and their stacks) alive during a gather on hosts with many containers
+func BenchmarkGatherBatchSize(b *testing.B) {
+    defer func(prev int) { *batchSize = prev }(*batchSize)
+    for _, size := range []int{0, 100, 20} {
+        b.Run(fmt.Sprintf("batch-size=%d", size), func(b *testing.B) {
+            *batchSize = size
+            cli := replayClient(b, []replayFrame{manyContainers(1000, fmt.Sprintf("b%d", size))})
+            gatherMetrics(cli) // baseline tick, first inspect of every container
+
+            b.ReportAllocs()
+            b.ResetTimer()
+            var peakG int
+            var peakStack uint64
+            for i := 0; i < b.N; i++ {
+                g, stack := peakDuring(func() { gatherMetrics(cli) })
+                peakG, peakStack = max(peakG, g), max(peakStack, stack)
+            }
+            b.ReportMetric(float64(peakG), "peak-goroutines")
+            b.ReportMetric(float64(peakStack), "peak-stack-bytes")
+        })
+    }
+}
+
+// A remote daemon answering with some latency: without an idle connection per worker, concurrent stats
+// calls keep opening new connections
+func BenchmarkRemoteGather(b *testing.B) {
+    rt := &replayTransport{frames: []replayFrame{manyContainers(50, "r")}, pos: -1}
+    var conns atomic.Int64
+    srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        time.Sleep(time.Millisecond)
+        resp, _ := rt.RoundTrip(r)
+        for k, v := range resp.Header { w.Header()[k] = v }
+        w.WriteHeader(resp.StatusCode)
+        io.Copy(w, resp.Body)
+    }))
+    srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+        if state == http.StateNew { conns.Add(1) }
+    }
+    srv.Start()
+    defer srv.Close()
+
+    tests := []struct {
+        name string
+        opts []client.Opt
+    }{
+        {"default transport", nil},
+        {"idle conn per worker", []client.Opt{withIdleConns(*maxWorkers)}},
+    }
+    for _, tt := range tests {
+        b.Run(tt.name, func(b *testing.B) {
+            cli, err := client.NewClientWithOpts(append([]client.Opt{client.WithHost("tcp://" + srv.Listener.Addr().String())}, tt.opts...)...)
+            if err != nil { b.Fatalf("creating client: %v", err) }
+            defer cli.Close()
+            gatherMetrics(cli)
+
+            b.ResetTimer()
+            start := conns.Load()
+            for i := 0; i < b.N; i++ { gatherMetrics(cli) }
+            b.ReportMetric(float64(conns.Load()-start)/float64(b.N), "conns/op")
+        })
+    }
+}
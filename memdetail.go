@@ -0,0 +1,41 @@
+package main
+
+import (
+    "sync"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Last page fault readings per container, for delta conversion
+type faultCounters struct {
+    pgfault    uint64
+    pgmajfault uint64
+}
+
+var (
+    faultHistory = make(map[string]faultCounters)
+    faultMutex   sync.Mutex
+)
+
+// collectDetailedMemory exports mapped file memory and page faults from the memory stats map (-detailed-memory).
+// Keys missing on this kernel or cgroup version are skipped.
+func collectDetailedMemory(cid string, stats map[string]uint64, labels prometheus.Labels) {
+    // cgroup v1 calls it mapped_file, v2 file_mapped
+    if mapped, ok := stats["mapped_file"]; ok {
+        setAggregated(gaugeMappedFile, labels, cid, float64(mapped), aggSum)
+    } else if mapped, ok := stats["file_mapped"]; ok {
+        setAggregated(gaugeMappedFile, labels, cid, float64(mapped), aggSum)
+    }
+
+    pgfault, okFault := stats["pgfault"]
+    pgmajfault, okMajFault := stats["pgmajfault"]
+    if !okFault && !okMajFault { return }
+
+    faultMutex.Lock()
+    prev, found := faultHistory[cid]
+    faultHistory[cid] = faultCounters{pgfault: pgfault, pgmajfault: pgmajfault}
+    faultMutex.Unlock()
+    if !found { return }
+    if d, ok := calcCounterDelta(pgfault, prev.pgfault); okFault && ok && d > 0 { counterPgfault.With(labels).Add(float64(d)) }
+    if d, ok := calcCounterDelta(pgmajfault, prev.pgmajfault); okMajFault && ok && d > 0 { counterPgmajfault.With(labels).Add(float64(d)) }
+}
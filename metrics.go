@@ -0,0 +1,357 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "math"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Process start, for exporter_uptime_seconds
+var startTime = time.Now()
+
+// Labels of every per-container series; extended by flags such as -group-label (see initMetrics)
+var containerLabelNames = []string{"name", "id"}
+
+// Environment variables exported as labels (-env-label)
+var envLabels []string
+
+var (
+    registry = prometheus.NewRegistry()
+
+    // Every per-container vector, so cleanupHistory can drop all series of a removed container
+    containerMetrics []interface{ DeletePartialMatch(prometheus.Labels) int }
+
+    // Metrics Gauges / Counters
+    gaugeCpu        *prometheus.GaugeVec
+    gaugeMemBytes   *prometheus.GaugeVec
+    gaugeMemRss     *prometheus.GaugeVec
+    gaugeMemLimit   *prometheus.GaugeVec
+    gaugeMemRatio   *prometheus.GaugeVec
+    counterNetRx    *prometheus.CounterVec
+    counterNetTx    *prometheus.CounterVec
+    gaugeNetRxRate  *prometheus.GaugeVec
+    gaugeNetTxRate  *prometheus.GaugeVec
+    gaugeNetIdle    *prometheus.GaugeVec
+    gaugeBlockRead  *prometheus.GaugeVec
+    gaugeBlockWrite *prometheus.GaugeVec
+
+    // Container configuration (from inspect)
+    gaugeCpuShares      *prometheus.GaugeVec
+    gaugeCPULimit       *prometheus.GaugeVec
+    gaugeMemReservation *prometheus.GaugeVec
+    gaugeMemSwappiness  *prometheus.GaugeVec
+    gaugeOomScoreAdj    *prometheus.GaugeVec
+    gaugeNetworkInfo    *prometheus.GaugeVec
+    networkInfoSeries   *seriesTracker
+    gaugeUlimit         *prometheus.GaugeVec
+    ulimitSeries        *seriesTracker
+    gaugeCgroupParent   *prometheus.GaugeVec
+    cgroupParentSeries  *seriesTracker
+    gaugeSecProfile     *prometheus.GaugeVec
+
+    // Published host ports (from the listing)
+    gaugePublishedPorts *prometheus.GaugeVec
+
+    // Filesystem (from the listing, with -sizes)
+    gaugeRwSize     *prometheus.GaugeVec
+    gaugeRootFsSize *prometheus.GaugeVec
+
+    // Volume quotas (from statfs, with -volume-quota)
+    gaugeVolumeUsed   *prometheus.GaugeVec
+    gaugeVolumeQuota  *prometheus.GaugeVec
+    volumeUsedSeries  *seriesTracker
+    volumeQuotaSeries *seriesTracker
+
+    // GPU (from nvidia-smi, with -gpu)
+    gaugeGpuMem   *prometheus.GaugeVec
+    gaugeGpuUtil  *prometheus.GaugeVec
+    gpuMemSeries  *seriesTracker
+    gpuUtilSeries *seriesTracker
+
+    // Memory details (from the memory stats map, with -detailed-memory)
+    gaugeMappedFile   *prometheus.GaugeVec
+    counterPgfault    *prometheus.CounterVec
+    counterPgmajfault *prometheus.CounterVec
+
+    // Run queue delay (from /proc, with -cpu-wait)
+    counterCPUWait *prometheus.CounterVec
+
+    // Pressure stall information (from the cgroup, with -pressure)
+    gaugeCPUPressure *prometheus.GaugeVec
+    gaugeMemPressure *prometheus.GaugeVec
+    gaugeIOPressure  *prometheus.GaugeVec
+
+    // Checkpoints (CRIU, with -checkpoints)
+    gaugeCheckpoints *prometheus.GaugeVec
+
+    // Processes (from top)
+    gaugeProcessCount *prometheus.GaugeVec
+
+    // Threshold alerts (from -alert-* flags)
+    gaugeAlert *prometheus.GaugeVec
+
+    // Weighted combination of CPU, memory and IO (with -pressure-score)
+    gaugePressureScore *prometheus.GaugeVec
+
+    // Lifecycle (from the events stream)
+    counterObservedRestarts *prometheus.CounterVec
+    gaugeCrashloop          *prometheus.GaugeVec
+    gaugeLastRestart        *prometheus.GaugeVec
+
+    // Per name rather than per container, since every recreation brings a new id (registered with -events)
+    counterRecreated = prometheus.NewCounterVec(prometheus.CounterOpts{Name: appName + "_container_recreated_total"}, []string{"name"})
+
+    // Host level
+    gaugeContainers      = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_containers"}, []string{"status"})
+    gaugeImageContainers = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_image_container_count"}, []string{"image"})
+
+    // Only registered once the daemon turned out to be local, see watchDockerRoot
+    gaugeRootFree = prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_docker_root_free_bytes"})
+
+    // Age of every listed container (any state), observed each tick; buckets from 10s to 30 days
+    histContainerAge = prometheus.NewHistogram(prometheus.HistogramOpts{
+        Name:    appName + "_container_age_seconds",
+        Buckets: []float64{10, 60, 300, 1800, 3600, 6 * 3600, 86400, 3 * 86400, 7 * 86400, 14 * 86400, 30 * 86400},
+    })
+
+    // Distribution of memory pressure across containers (objectives come from -memory-ratio-quantiles)
+    summaryMemRatio prometheus.Summary
+
+    // Exporter internals
+    gaugeWorkerSaturation = prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_worker_saturation_ratio"})
+    counterThrottled      = prometheus.NewCounter(prometheus.CounterOpts{Name: appName + "_daemon_rps_throttled_total"})
+    gaugeBelowMin         = prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_below_min_containers"})
+    counterTruncated      = prometheus.NewCounter(prometheus.CounterOpts{Name: appName + "_containers_truncated_total"})
+    gaugeSampled          = prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_sampled_containers"})
+    gaugeScrapeSuccess    = prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_scrape_success_ratio"})
+    counterCPUClamped     = prometheus.NewCounter(prometheus.CounterOpts{Name: appName + "_cpu_clamped_total"})
+    gaugeUptime           = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: appName + "_exporter_uptime_seconds"}, func() float64 { return time.Since(startTime).Seconds() })
+    gaugeSeries           = prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_exported_series_total"})
+    gaugePollingPaused    = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: appName + "_polling_paused"}, pausedValue)
+    gaugeOldestCall       = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: appName + "_oldest_inflight_call_seconds"}, oldestCallAge)
+
+    // Docker API requests by call type (list, stats, inspect, ...)
+    counterAPICalls = prometheus.NewCounterVec(prometheus.CounterOpts{Name: appName + "_docker_api_calls_total"}, []string{"call"})
+
+    // Failed stats calls: containers removed mid-gather (benign) vs. daemon/connection problems
+    counterStatsNotFound     = prometheus.NewCounter(prometheus.CounterOpts{Name: appName + "_stats_not_found_total"})
+    counterStatsTransportErr = prometheus.NewCounter(prometheus.CounterOpts{Name: appName + "_stats_transport_errors_total"})
+)
+
+// initMetrics builds and registers the metrics whose shape depends on flags; call it after flag.Parse
+func initMetrics() {
+    if *collapseBy == "image" { containerLabelNames = []string{"image"} }
+    if *aggregateBy != "" { containerLabelNames = []string{labelName(*aggregateBy)} }
+    if *groupLabel != "" { containerLabelNames = append(containerLabelNames, "group") }
+    if *roles { containerLabelNames = append(containerLabelNames, "role") }
+    for _, key := range strings.Split(*envLabel, ",") {
+        if key = strings.TrimSpace(key); key == "" { continue }
+        envLabels = append(envLabels, key)
+        containerLabelNames = append(containerLabelNames, envLabelName(key))
+    }
+
+    gaugeCpu = newContainerGauge("cpu_usage_ratio")
+    gaugeMemBytes = newContainerGauge("memory_usage_bytes")
+    gaugeMemRss = newContainerGauge("memory_usage_rss_bytes")
+    gaugeMemLimit = newContainerGauge("memory_limit_bytes")
+    gaugeMemRatio = newContainerGauge("memory_usage_ratio")
+    var netExtra []string
+    if *sharedNetns { netExtra = append(netExtra, "shared_netns") }
+    counterNetRx = newContainerCounter("network_received_bytes_total", netExtra...)
+    counterNetTx = newContainerCounter("network_transmitted_bytes_total", netExtra...)
+    gaugeNetRxRate = newContainerGauge("network_receive_bytes_per_second", netExtra...)
+    gaugeNetTxRate = newContainerGauge("network_transmit_bytes_per_second", netExtra...)
+    gaugeNetIdle = newContainerGauge("container_seconds_since_network_activity")
+    gaugeBlockRead = newContainerGauge("blockio_read_bytes")
+    gaugeBlockWrite = newContainerGauge("blockio_written_bytes")
+
+    gaugeCpuShares = newContainerGauge("container_cpu_shares")
+    gaugeCPULimit = newContainerGauge("container_cpu_effective_limit_cores")
+    gaugeMemReservation = newContainerGauge("container_memory_reservation_bytes")
+    gaugeMemSwappiness = newContainerGauge("container_memory_swappiness")
+    gaugeOomScoreAdj = newContainerGauge("container_oom_score_adj")
+    gaugeNetworkInfo = newContainerGauge("container_network_info", "network", "ip")
+    networkInfoSeries = newSeriesTracker(gaugeNetworkInfo)
+    gaugeUlimit = newContainerGauge("container_ulimit", "ulimit", "kind")
+    ulimitSeries = newSeriesTracker(gaugeUlimit)
+    gaugeCgroupParent = newContainerGauge("container_cgroup_parent_info", "cgroup_parent")
+    cgroupParentSeries = newSeriesTracker(gaugeCgroupParent)
+    gaugeSecProfile = newContainerGauge("container_security_profile", "profile")
+
+    gaugePublishedPorts = newContainerGauge("container_published_ports")
+
+    gaugeRwSize = newContainerGauge("container_rw_size_bytes")
+    gaugeRootFsSize = newContainerGauge("container_root_fs_size_bytes")
+
+    gaugeVolumeUsed = newContainerGauge("volume_used_bytes", "volume")
+    gaugeVolumeQuota = newContainerGauge("volume_quota_bytes", "volume")
+    volumeUsedSeries = newSeriesTracker(gaugeVolumeUsed)
+    volumeQuotaSeries = newSeriesTracker(gaugeVolumeQuota)
+
+    gaugeGpuMem = newContainerGauge("container_gpu_memory_bytes")
+    gaugeGpuUtil = newContainerGauge("container_gpu_utilization_ratio")
+    gpuMemSeries = newSeriesTracker(gaugeGpuMem)
+    gpuUtilSeries = newSeriesTracker(gaugeGpuUtil)
+
+    gaugeMappedFile = newContainerGauge("container_mapped_file_bytes")
+    counterPgfault = newContainerCounter("container_pgfault_total")
+    counterPgmajfault = newContainerCounter("container_pgmajfault_total")
+
+    counterCPUWait = newContainerCounter("container_cpu_wait_seconds_total")
+
+    gaugeCPUPressure = newContainerGauge("container_cpu_pressure_ratio")
+    gaugeMemPressure = newContainerGauge("container_memory_pressure_ratio")
+    gaugeIOPressure = newContainerGauge("container_io_pressure_ratio")
+
+    gaugeCheckpoints = newContainerGauge("container_checkpoints")
+
+    gaugeProcessCount = newContainerGauge("container_process_count", "command")
+
+    gaugeAlert = newContainerGauge("container_alert", "reason")
+
+    gaugePressureScore = newContainerGauge("container_pressure_score")
+
+    counterObservedRestarts = newContainerCounter("container_observed_restarts_total")
+    gaugeCrashloop = newContainerGauge("container_crashloop")
+    gaugeLastRestart = newContainerGauge("container_last_restart_timestamp_seconds")
+
+    summaryMemRatio = prometheus.NewSummary(prometheus.SummaryOpts{
+        Name:       appName + "_memory_ratio_summary",
+        Objectives: memRatioObjectives(),
+    })
+
+    mustRegister(
+        gaugeContainers,
+        gaugeImageContainers,
+        histContainerAge,
+        summaryMemRatio,
+        gaugeWorkerSaturation,
+        counterTruncated,
+        gaugeSampled,
+        gaugeScrapeSuccess,
+        gaugeOldestCall,
+        gaugePollingPaused,
+        gaugeSeries,
+        gaugeUptime,
+        counterCPUClamped,
+        counterAPICalls,
+        counterStatsNotFound,
+        counterStatsTransportErr,
+    )
+    mustRegister(newDataAgeCollector())
+    if *watchEvts { mustRegister(counterRecreated) }
+    if *minContainers > 0 { mustRegister(gaugeBelowMin) }
+    if *daemonRPS > 0 { mustRegister(counterThrottled) }
+}
+
+// updateSeriesCount counts the series currently exported by the registry (a summary or histogram counts once)
+func updateSeriesCount() {
+    mfs, err := registry.Gather()
+    if err != nil { return }
+    n := 0
+    for _, mf := range mfs { n += len(mf.Metric) }
+    gaugeSeries.Set(float64(n))
+}
+
+// mustRegister registers collectors with the exporter's registry. A clash (the same metric name twice, e.g. after
+// renaming metrics) exits with a message naming the metric instead of MustRegister's panic and stack trace.
+func mustRegister(cs ...prometheus.Collector) {
+    if err := registerAll(registry, cs...); err != nil { log.Fatalf("FATAL: Registering metrics: %v", err) }
+}
+
+// registerAll registers collectors with reg, stopping at the first that fails
+func registerAll(reg *prometheus.Registry, cs ...prometheus.Collector) error {
+    for _, c := range cs {
+        if err := reg.Register(c); err != nil {
+            descs := make(chan *prometheus.Desc, 1)
+            go func() { c.Describe(descs); close(descs) }()
+            desc := <-descs
+            for range descs {}
+            return fmt.Errorf("%v: %v", desc, err)
+        }
+    }
+    return nil
+}
+
+// newContainerGauge creates and registers a gauge vector labeled per container (plus extra labels)
+func newContainerGauge(name string, extra ...string) *prometheus.GaugeVec {
+    vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_" + name}, append(slices.Clone(containerLabelNames), extra...))
+    mustRegister(vec)
+    containerMetrics = append(containerMetrics, vec)
+    return vec
+}
+
+// newContainerCounter creates and registers a counter vector labeled per container (plus extra labels)
+func newContainerCounter(name string, extra ...string) *prometheus.CounterVec {
+    vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: appName + "_" + name}, append(slices.Clone(containerLabelNames), extra...))
+    mustRegister(vec)
+    containerMetrics = append(containerMetrics, vec)
+    return vec
+}
+
+// envLabelName turns an environment variable name into a label name with an env_ prefix
+func envLabelName(key string) string {
+    return "env_" + labelName(key)
+}
+
+// labelName turns a container label key or variable name into a valid label name (invalid characters as _)
+func labelName(key string) string {
+    return strings.Map(func(r rune) rune {
+        if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') { return r }
+        return '_'
+    }, key)
+}
+
+// sanitizeLabelValue keeps user controlled label values (e.g. env) printable and bounded
+func sanitizeLabelValue(v string) string {
+    v = strings.Map(func(r rune) rune {
+        if unicode.IsPrint(r) { return r }
+        return -1
+    }, strings.ToValidUTF8(v, ""))
+    if len(v) > 128 { v = strings.ToValidUTF8(v[:128], "") }
+    return v
+}
+
+// memRatioObjectives parses -memory-ratio-quantiles into summary objectives
+func memRatioObjectives() map[float64]float64 {
+    objectives := make(map[float64]float64)
+    for _, f := range strings.Split(*memQuantiles, ",") {
+        q, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+        if err != nil || q <= 0 || q >= 1 {
+            log.Fatalf("FATAL: Invalid quantile %q in -memory-ratio-quantiles", f)
+        }
+        // Allowed rank error shrinks towards the tails
+        objectives[q] = math.Min(q, 1-q) / 10
+    }
+    return objectives
+}
+
+// Flags that are left out of the config info metric (irrelevant or sensitive values)
+var configInfoSkip = map[string]bool{
+    "version": true,
+    "v":       true,
+    "header":  true,
+}
+
+// registerConfigInfo exposes the effective flag values as a constant info metric,
+// so config drift across hosts can be spotted from Prometheus.
+func registerConfigInfo() {
+    var names, values []string
+    flag.VisitAll(func(f *flag.Flag) {
+        if configInfoSkip[f.Name] { return }
+        names = append(names, strings.ReplaceAll(f.Name, "-", "_"))
+        values = append(values, f.Value.String())
+    })
+
+    gaugeConfigInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: appName + "_config_info"}, names)
+    gaugeConfigInfo.WithLabelValues(values...).Set(1)
+    mustRegister(gaugeConfigInfo)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// A clashing registration must surface as an error naming the metric (mustRegister turns it into a clean exit), not a panic
+func TestRegisterAllDuplicate(t *testing.T) {
+    tests := []struct {
+        name string
+        cs   []prometheus.Collector
+        err  bool
+    }{
+        {"distinct", []prometheus.Collector{
+            prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_test_a"}),
+            prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_test_b"}),
+        }, false},
+        {"same name twice", []prometheus.Collector{
+            prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_test_a"}),
+            prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_test_a"}),
+        }, true},
+        {"same name, different type", []prometheus.Collector{
+            prometheus.NewGauge(prometheus.GaugeOpts{Name: appName + "_test_a"}),
+            prometheus.NewCounterVec(prometheus.CounterOpts{Name: appName + "_test_a"}, []string{"id"}),
+        }, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := registerAll(prometheus.NewRegistry(), tt.cs...)
+            switch {
+            case tt.err && err == nil:
+                t.Errorf("expected an error")
+            case !tt.err && err != nil:
+                t.Errorf("unexpected error: %v", err)
+            case err != nil && !strings.Contains(err.Error(), appName+"_test_a"):
+                t.Errorf("error doesn't name the metric: %v", err)
+            }
+        })
+    }
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "log"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/docker/docker/api/types"
+    "golang.org/x/time/rate"
+)
+
+// Container labels through which app owners adjust collection of their own containers
+const (
+    labelDisable  = "dockerstats.disable"  // true: never collected
+    labelInterval = "dockerstats.interval" // duration: collected at most this often (longer than -interval to have an effect)
+)
+
+// Last time each container with a dockerstats.interval label was collected
+var (
+    lastCollected      = make(map[string]time.Time)
+    lastCollectedMutex sync.Mutex
+)
+
+// An invalid label is seen every tick, only log it once a minute
+var overrideErrorLog = rate.Sometimes{Interval: time.Minute}
+
+// disabledByLabel reports whether a container opted out with dockerstats.disable
+func disabledByLabel(labels map[string]string) bool {
+    disabled, _ := strconv.ParseBool(labels[labelDisable])
+    return disabled
+}
+
+// dueContainers drops the containers whose dockerstats.interval hasn't elapsed since their last collection.
+// Like with sampling, skipped containers are marked as seen so cleanupHistory keeps them.
+func dueContainers(containers []types.Container) []types.Container {
+    now := time.Now()
+    due := containers[:0]
+    var skipped []types.Container
+    lastCollectedMutex.Lock()
+    for _, c := range containers {
+        raw, ok := c.Labels[labelInterval]
+        if !ok {
+            due = append(due, c)
+            continue
+        }
+        every, err := time.ParseDuration(raw)
+        if err != nil {
+            overrideErrorLog.Do(func() { log.Printf("WARN: Ignoring invalid %s=%q on %s: %v", labelInterval, raw, containerName(c), err) })
+            due = append(due, c)
+            continue
+        }
+        // Half a tick of slack, so e.g. 30s with -interval=10 doesn't drift to every fourth tick
+        if last, seen := lastCollected[c.ID]; seen && now.Sub(last) < every-time.Duration(*interval)*time.Second/2 {
+            skipped = append(skipped, c)
+            continue
+        }
+        lastCollected[c.ID] = now
+        due = append(due, c)
+    }
+    lastCollectedMutex.Unlock()
+    markSeen(skipped)
+    return due
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+)
+
+// prefixTransport serves the Docker API from below a path prefix (-api-path-prefix), for daemons
+// published under a sub-path by a reverse proxy or gateway. Covers /_ping too, so version
+// negotiation goes through the prefix like every other call.
+type prefixTransport struct {
+    next   http.RoundTripper
+    prefix string // leading slash, no trailing slash
+}
+
+func newPrefixTransport(next http.RoundTripper, prefix string) *prefixTransport {
+    return &prefixTransport{next: next, prefix: "/" + strings.Trim(prefix, "/")}
+}
+
+func (t *prefixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    // RoundTrippers must not modify the caller's request
+    req = req.Clone(req.Context())
+    req.URL.Path = t.prefix + req.URL.Path
+    if req.URL.RawPath != "" { req.URL.RawPath = t.prefix + req.URL.RawPath }
+    return t.next.RoundTrip(req)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sync/atomic"
+)
+
+// Set while polling is paused for maintenance (SIGUSR1 or POST /admin/pause); /metrics keeps serving the last values
+var pollingPaused atomic.Bool
+
+// togglePause pauses or resumes polling and returns the new state
+func togglePause(source string) bool {
+    for {
+        old := pollingPaused.Load()
+        if pollingPaused.CompareAndSwap(old, !old) {
+            if old {
+                logInfo("Polling resumed (%s)", source)
+            } else {
+                logInfo("Polling paused (%s), serving the last collected metrics", source)
+            }
+            return !old
+        }
+    }
+}
+
+// pausedValue is polling_paused: 1 while paused, else 0
+func pausedValue() float64 {
+    if pollingPaused.Load() { return 1 }
+    return 0
+}
+
+// handlePause toggles the maintenance pause
+func handlePause(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", http.MethodPost)
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if togglePause("POST /admin/pause") {
+        fmt.Fprintln(w, "Polling paused")
+    } else {
+        fmt.Fprintln(w, "Polling resumed")
+    }
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+    "os"
+    "os/signal"
+    "syscall"
+)
+
+// watchPauseSignal toggles the maintenance pause on every SIGUSR1
+func watchPauseSignal() {
+    sigs := make(chan os.Signal, 1)
+    signal.Notify(sigs, syscall.SIGUSR1)
+    go func() {
+        for range sigs { togglePause("SIGUSR1") }
+    }()
+}
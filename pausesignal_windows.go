@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// watchPauseSignal does nothing on Windows, which has no SIGUSR1; use POST /admin/pause instead
+func watchPauseSignal() {}
@@ -0,0 +1,29 @@
+package main
+
+import (
+    "log"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// Path of the pidfile once written, for removePidfile
+var pidfileWritten string
+
+// writePidfile writes the PID to path (-pidfile); watchShutdown removes it again on SIGINT/SIGTERM.
+// A file left over from a process that didn't shut down cleanly is overwritten.
+func writePidfile(path string) {
+    if raw, err := os.ReadFile(path); err == nil {
+        log.Printf("WARN: Overwriting existing pidfile %s (pid %s), assuming it is stale", path, strings.TrimSpace(string(raw)))
+    }
+    if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+        log.Fatalf("FATAL: Unable to write pidfile: %v", err)
+    }
+    pidfileWritten = path
+}
+
+// removePidfile removes the pidfile written by this process, if any
+func removePidfile() {
+    if pidfileWritten == "" { return }
+    if err := os.Remove(pidfileWritten); err != nil { log.Printf("WARN: Unable to remove pidfile: %v", err) }
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+// workerPool bounds the number of concurrent Docker API calls and measures how long
+// every worker was busy at the same time (i.e. the pool was the bottleneck).
+type workerPool struct {
+    slots chan struct{}
+
+    mu        sync.Mutex
+    active    int
+    fullSince time.Time
+    fullTotal time.Duration
+}
+
+func newWorkerPool(size int) *workerPool {
+    return &workerPool{slots: make(chan struct{}, size)}
+}
+
+func (p *workerPool) acquire() {
+    p.slots <- struct{}{}
+    p.mu.Lock()
+    p.active++
+    if p.active == cap(p.slots) { p.fullSince = time.Now() }
+    p.mu.Unlock()
+}
+
+func (p *workerPool) release() {
+    p.mu.Lock()
+    if p.active == cap(p.slots) { p.fullTotal += time.Since(p.fullSince) }
+    p.active--
+    p.mu.Unlock()
+    <-p.slots
+}
+
+// saturation returns the share of elapsed during which all workers were busy
+func (p *workerPool) saturation(elapsed time.Duration) float64 {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if elapsed <= 0 { return 0 }
+    return float64(p.fullTotal) / float64(elapsed)
+}
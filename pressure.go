@@ -0,0 +1,57 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Set in main when -pressure is on, the daemon is local and the host uses cgroup v2
+var pressureAvailable bool
+
+// cgroupV2Available reports whether the unified hierarchy is mounted at /sys/fs/cgroup
+func cgroupV2Available() bool {
+    _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers")
+    return err == nil
+}
+
+// collectPressure exports the "some" 10s average of the container cgroup's pressure stall information:
+// the share of time at least one task waited for CPU, memory or IO. Missing files (kernel without PSI) are skipped.
+// Returns the IO value (percent) for container_pressure_score.
+func collectPressure(cid string, pid int, labels prometheus.Labels) (io float64, hasIO bool) {
+    if pid <= 0 { return 0, false }
+    raw, err := containerProcCgroup(pid, cid)
+    if err != nil { return 0, false }
+    var dir string
+    for _, line := range strings.Split(strings.TrimSpace(raw), "\n") {
+        if p, ok := strings.CutPrefix(line, "0::"); ok { dir = filepath.Join("/sys/fs/cgroup", p) }
+    }
+    if dir == "" { return 0, false }
+
+    for resource, vec := range map[string]*prometheus.GaugeVec{"cpu": gaugeCPUPressure, "memory": gaugeMemPressure, "io": gaugeIOPressure} {
+        avg, ok := readPressure(filepath.Join(dir, resource+".pressure"))
+        if !ok { continue }
+        setAggregated(vec, labels, cid, scaleRatio(avg), aggMax)
+        if resource == "io" { io, hasIO = avg, true }
+    }
+    return io, hasIO
+}
+
+// readPressure returns avg10 (percent) of the "some" line of a *.pressure file:
+// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPressure(file string) (float64, bool) {
+    raw, err := os.ReadFile(file)
+    if err != nil { return 0, false }
+    for _, line := range strings.Split(string(raw), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) < 2 || fields[0] != "some" { continue }
+        if v, ok := strings.CutPrefix(fields[1], "avg10="); ok {
+            avg, err := strconv.ParseFloat(v, 64)
+            return avg, err == nil
+        }
+    }
+    return 0, false
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "regexp"
+    "sync"
+
+    "github.com/docker/docker/api"
+)
+
+// One recorded gather cycle: the raw ContainerList answer plus the raw stats and inspect data of every container
+type replayFrame struct {
+    Containers json.RawMessage            `json:"containers"`
+    Stats      map[string]json.RawMessage `json:"stats"`
+    Inspect    map[string]json.RawMessage `json:"inspect,omitempty"`
+}
+
+var (
+    apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+    statsPath        = regexp.MustCompile(`^/containers/([^/]+)/stats$`)
+    inspectPath      = regexp.MustCompile(`^/containers/([^/]+)/json$`)
+)
+
+// apiPath strips the version prefix (e.g. /v1.43) from a Docker API request path
+func apiPath(p string) string {
+    return apiVersionPrefix.ReplaceAllString(p, "")
+}
+
+// The -record transport, closed on shutdown so the last frame isn't lost
+var recorder *recordTransport
+
+// recordTransport tees the list and stats responses into a JSON-lines file, one frame per gather cycle.
+// A frame is written out when the next cycle starts (i.e. on the next unfiltered ContainerList call) or on Close.
+type recordTransport struct {
+    next http.RoundTripper
+
+    mu    sync.Mutex
+    file  *os.File
+    enc   *json.Encoder
+    frame *replayFrame
+}
+
+func newRecordTransport(next http.RoundTripper, path string) (*recordTransport, error) {
+    f, err := os.Create(path)
+    if err != nil { return nil, err }
+    return &recordTransport{next: next, file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Close writes out the pending frame and closes the file; later responses are no longer recorded
+func (t *recordTransport) Close() error {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.file == nil { return nil }
+    var err error
+    if t.frame != nil { err = t.enc.Encode(t.frame) }
+    if cerr := t.file.Close(); err == nil { err = cerr }
+    t.file, t.frame = nil, nil
+    return err
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    resp, err := t.next.RoundTrip(req)
+    if err != nil || resp.StatusCode != http.StatusOK { return resp, err }
+
+    p := apiPath(req.URL.Path)
+    // Only the gather cycle's list starts a frame; id-filtered lists (e.g. on container start events) aren't replayed
+    isList := p == "/containers/json" && req.URL.Query().Get("filters") == ""
+    sm := statsPath.FindStringSubmatch(p)
+    im := inspectPath.FindStringSubmatch(p)
+    if !isList && sm == nil && im == nil { return resp, nil }
+
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if err != nil { return nil, err }
+    resp.Body = io.NopCloser(bytes.NewReader(body))
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    switch {
+    case t.file == nil:
+        // closed on shutdown
+    case isList:
+        if t.frame != nil {
+            if err := t.enc.Encode(t.frame); err != nil { log.Printf("ERROR: Writing record file: %v", err) }
+        }
+        t.frame = &replayFrame{
+            Containers: body,
+            Stats:      make(map[string]json.RawMessage),
+            Inspect:    make(map[string]json.RawMessage),
+        }
+    case t.frame == nil:
+        // nothing listed yet, no frame to attach to
+    case sm != nil:
+        t.frame.Stats[sm[1]] = body
+    default:
+        t.frame.Inspect[im[1]] = body
+    }
+    return resp, nil
+}
+
+// replayTransport answers Docker API calls from previously recorded frames instead of a daemon.
+// Every ContainerList call advances to the next frame, looping at the end of the recording.
+type replayTransport struct {
+    mu     sync.Mutex
+    frames []replayFrame
+    pos    int
+}
+
+func newReplayTransport(path string) (*replayTransport, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+
+    t := &replayTransport{pos: -1}
+    dec := json.NewDecoder(f)
+    for {
+        var fr replayFrame
+        if err := dec.Decode(&fr); err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("frame %d: %w", len(t.frames)+1, err)
+        }
+        t.frames = append(t.frames, fr)
+    }
+    if len(t.frames) == 0 { return nil, fmt.Errorf("no frames in %s", path) }
+    return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    p := apiPath(req.URL.Path)
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    switch {
+    case p == "/_ping":
+        resp := replayResponse(req, http.StatusOK, []byte("OK"))
+        resp.Header.Set("Api-Version", api.DefaultVersion)
+        return resp, nil
+    case p == "/containers/json":
+        t.pos++
+        if t.pos == len(t.frames) {
+            logInfo("Replay reached the end of the recording, starting over")
+            t.pos = 0
+        }
+        return replayResponse(req, http.StatusOK, t.frames[t.pos].Containers), nil
+    }
+
+    if t.pos >= 0 {
+        if m := statsPath.FindStringSubmatch(p); m != nil { return replayContainer(req, t.frames[t.pos].Stats, m[1]), nil }
+        if m := inspectPath.FindStringSubmatch(p); m != nil { return replayContainer(req, t.frames[t.pos].Inspect, m[1]), nil }
+    }
+    return replayResponse(req, http.StatusNotFound, []byte(`{"message":"not available in replay mode"}`)), nil
+}
+
+func replayContainer(req *http.Request, recorded map[string]json.RawMessage, id string) *http.Response {
+    if raw, ok := recorded[id]; ok { return replayResponse(req, http.StatusOK, raw) }
+    return replayResponse(req, http.StatusNotFound, []byte(`{"message":"No such container: `+id+`"}`))
+}
+
+func replayResponse(req *http.Request, code int, body []byte) *http.Response {
+    return &http.Response{
+        Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+        StatusCode:    code,
+        Proto:         "HTTP/1.1",
+        ProtoMajor:    1,
+        ProtoMinor:    1,
+        Header:        http.Header{"Content-Type": []string{"application/json"}},
+        Body:          io.NopCloser(bytes.NewReader(body)),
+        ContentLength: int64(len(body)),
+        Request:       req,
+    }
+}
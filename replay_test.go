@@ -0,0 +1,56 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// Only the unfiltered gather list starts a frame, and Close writes out the last one
+func TestRecordTransportFrames(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "rec.jsonl")
+    rt, err := newRecordTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+        body := `"` + req.URL.RawQuery + `"`
+        return replayResponse(req, http.StatusOK, []byte(body)), nil
+    }), path)
+    if err != nil { t.Fatal(err) }
+
+    for _, url := range []string{
+        "http://docker/v1.43/containers/json?all=1",
+        "http://docker/v1.43/containers/a/stats?stream=0",
+        "http://docker/v1.43/containers/json?filters=%7B%22id%22%3A%7B%22a%22%3Atrue%7D%7D",
+        "http://docker/v1.43/containers/a/json",
+        "http://docker/v1.43/containers/json?all=2",
+        "http://docker/v1.43/containers/b/stats?stream=0",
+    } {
+        req, _ := http.NewRequest(http.MethodGet, url, nil)
+        resp, err := rt.RoundTrip(req)
+        if err != nil { t.Fatal(err) }
+        io.Copy(io.Discard, resp.Body)
+    }
+    if err := rt.Close(); err != nil { t.Fatal(err) }
+
+    raw, err := os.ReadFile(path)
+    if err != nil { t.Fatal(err) }
+    lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+    if len(lines) != 2 { t.Fatalf("got %d frames, want 2", len(lines)) }
+    tests := []struct {
+        containers string
+        stats      string
+        inspect    int
+    }{
+        {`"all=1"`, "a", 1},
+        {`"all=2"`, "b", 0},
+    }
+    for i, tt := range tests {
+        var fr replayFrame
+        if err := json.Unmarshal([]byte(lines[i]), &fr); err != nil { t.Fatal(err) }
+        if string(fr.Containers) != tt.containers { t.Errorf("frame %d: containers = %s, want %s", i, fr.Containers, tt.containers) }
+        if _, ok := fr.Stats[tt.stats]; !ok || len(fr.Stats) != 1 { t.Errorf("frame %d: stats = %v, want only %s", i, fr.Stats, tt.stats) }
+        if len(fr.Inspect) != tt.inspect { t.Errorf("frame %d: %d inspect answers, want %d", i, len(fr.Inspect), tt.inspect) }
+    }
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "math"
+    "math/rand"
+    "sort"
+    "time"
+
+    "github.com/docker/docker/api/types"
+)
+
+// Position of the next -sample-rate window in the id-ordered container list; starts at random
+var sampleOffset = -1
+
+// sampleContainers picks the share of containers given by -sample-rate for this tick. Windows rotate
+// over the containers ordered by id, so every container is collected at least once per 1/rate ticks.
+// CPU ratios stay correct across the skipped ticks since both the container and the system counter
+// deltas span the same gap. Skipped containers are marked as seen, so cleanupHistory keeps them.
+func sampleContainers(containers []types.Container) []types.Container {
+    if len(containers) == 0 { return containers }
+    sort.Slice(containers, func(i, j int) bool { return containers[i].ID < containers[j].ID })
+
+    n := int(math.Ceil(float64(len(containers)) * *sampleRate))
+    if sampleOffset < 0 { sampleOffset = rand.Intn(len(containers)) }
+    first := sampleOffset % len(containers)
+    sampleOffset = first + n
+
+    sampled := make([]types.Container, 0, n)
+    skipped := make([]types.Container, 0, len(containers)-n)
+    for i := range containers {
+        c := containers[(first+i)%len(containers)]
+        if i < n {
+            sampled = append(sampled, c)
+        } else {
+            skipped = append(skipped, c)
+        }
+    }
+
+    markSeen(skipped)
+    return sampled
+}
+
+// markSeen keeps containers skipped on this tick in the history, as if they had been collected
+func markSeen(skipped []types.Container) {
+    now := time.Now()
+    historyMutex.Lock()
+    for _, c := range skipped {
+        if snap, ok := cpuHistory[c.ID]; ok {
+            snap.lastSeen = now
+            cpuHistory[c.ID] = snap
+        }
+    }
+    historyMutex.Unlock()
+}
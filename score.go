@@ -0,0 +1,52 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Weights of the components of container_pressure_score (-pressure-score)
+type scoreWeights struct {
+    cpu    float64
+    memory float64
+    io     float64
+}
+
+// Parsed -pressure-score, set in main
+var pressureWeights scoreWeights
+
+// Component values (0-100) of one container's score; io needs -pressure
+type scoreParts struct {
+    cpu, memory, io          float64
+    hasCPU, hasMemory, hasIO bool
+}
+
+// parseScoreWeights parses cpu=1,memory=2,io=1; components left out weigh 0
+func parseScoreWeights(s string) (scoreWeights, error) {
+    var w scoreWeights
+    for _, pair := range strings.Split(s, ",") {
+        k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+        weight, err := strconv.ParseFloat(v, 64)
+        if !ok || err != nil || weight < 0 { return w, fmt.Errorf("invalid weight %q (expected name=non-negative number)", pair) }
+        switch k {
+        case "cpu": w.cpu = weight
+        case "memory": w.memory = weight
+        case "io": w.io = weight
+        default: return w, fmt.Errorf("unknown component %q (expected cpu, memory or io)", k)
+        }
+    }
+    if w.cpu+w.memory+w.io == 0 { return w, fmt.Errorf("all weights are 0") }
+    return w, nil
+}
+
+// score is the weighted mean of the available components, so a missing one (no -pressure for io,
+// no CPU value on a container's first tick) doesn't drag the score down
+func (w scoreWeights) score(p scoreParts) (float64, bool) {
+    var sum, total float64
+    if p.hasCPU { sum, total = sum+w.cpu*p.cpu, total+w.cpu }
+    if p.hasMemory { sum, total = sum+w.memory*p.memory, total+w.memory }
+    if p.hasIO { sum, total = sum+w.io*p.io, total+w.io }
+    if total == 0 { return 0, false }
+    return sum / total, true
+}
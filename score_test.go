@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestScore(t *testing.T) {
+    w := scoreWeights{cpu: 1, memory: 3, io: 2}
+    tests := []struct {
+        name  string
+        parts scoreParts
+        want  float64
+        ok    bool
+    }{
+        // (1 * 30 + 3 * 60 + 2 * 90) / 6
+        {"all components", scoreParts{cpu: 30, memory: 60, io: 90, hasCPU: true, hasMemory: true, hasIO: true}, 65, true},
+        // (1 * 20 + 3 * 60) / 4, io left out as unavailable
+        {"io unavailable", scoreParts{cpu: 20, memory: 60, hasCPU: true, hasMemory: true}, 50, true},
+        {"first tick, no cpu yet", scoreParts{memory: 60, hasMemory: true}, 60, true},
+        {"nothing available", scoreParts{}, 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, ok := w.score(tt.parts)
+            if got != tt.want || ok != tt.ok { t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.ok) }
+        })
+    }
+}
+
+func TestParseScoreWeights(t *testing.T) {
+    tests := []struct {
+        in   string
+        want scoreWeights
+        err  bool
+    }{
+        {"cpu=1,memory=2,io=1", scoreWeights{cpu: 1, memory: 2, io: 1}, false},
+        {"memory=0.5", scoreWeights{memory: 0.5}, false},
+        {" cpu=1 , io=3", scoreWeights{cpu: 1, io: 3}, false},
+        {"cpu=0,memory=0", scoreWeights{}, true},
+        {"cpu=-1", scoreWeights{}, true},
+        {"disk=1", scoreWeights{}, true},
+        {"cpu", scoreWeights{}, true},
+    }
+    for _, tt := range tests {
+        got, err := parseScoreWeights(tt.in)
+        switch {
+        case tt.err && err == nil:
+            t.Errorf("%q: expected an error", tt.in)
+        case !tt.err && err != nil:
+            t.Errorf("%q: unexpected error: %v", tt.in, err)
+        case !tt.err && got != tt.want:
+            t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+        }
+    }
+}
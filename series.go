@@ -0,0 +1,45 @@
+package main
+
+import (
+    "maps"
+    "sync"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// seriesTracker remembers which series a container exported on a vector with extra labels
+// (one per network, ulimit, ...), so series that disappear between ticks can be deleted.
+type seriesTracker struct {
+    vec *prometheus.GaugeVec
+
+    mu   sync.Mutex
+    last map[string][]prometheus.Labels
+}
+
+func newSeriesTracker(vec *prometheus.GaugeVec) *seriesTracker {
+    return &seriesTracker{vec: vec, last: make(map[string][]prometheus.Labels)}
+}
+
+// update records the series exported for cid this tick and deletes the ones no longer present
+func (t *seriesTracker) update(cid string, current []prometheus.Labels) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    for _, old := range t.last[cid] {
+        stale := true
+        for _, l := range current {
+            if maps.Equal(old, l) {
+                stale = false
+                break
+            }
+        }
+        if stale { t.vec.Delete(old) }
+    }
+    t.last[cid] = current
+}
+
+// forget drops the bookkeeping of a removed container (its series are deleted by cleanupHistory)
+func (t *seriesTracker) forget(cid string) {
+    t.mu.Lock()
+    delete(t.last, cid)
+    t.mu.Unlock()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+    "log"
+    "os"
+    "os/signal"
+    "syscall"
+)
+
+// watchShutdown exits cleanly on SIGINT/SIGTERM instead of leaving the pidfile and a truncated -record file behind
+func watchShutdown() {
+    sigs := make(chan os.Signal, 1)
+    signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        sig := <-sigs
+        logInfo("Received %v, shutting down", sig)
+        shutdown()
+        os.Exit(0)
+    }()
+}
+
+// shutdown removes the pidfile and writes out the last recorded frame
+func shutdown() {
+    removePidfile()
+    if recorder != nil {
+        // A running gather cycle is waited for, so the last frame is complete
+        gatherMutex.Lock()
+        if err := recorder.Close(); err != nil { log.Printf("ERROR: Writing record file: %v", err) }
+    }
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "log"
+    "net"
+    "sort"
+    "strings"
+
+    dto "github.com/prometheus/client_model/go"
+)
+
+// Max payload per UDP packet, safe for the usual 1500 byte MTU
+const statsdPacketSize = 1400
+
+// statsdEmitter pushes the gathered metrics to a StatsD daemon over UDP after every tick.
+// Gauges are sent as gauges, counters as the increase since the previous push.
+type statsdEmitter struct {
+    conn net.Conn
+    tags bool
+
+    // Last pushed value of every counter series, to send increments
+    counters map[string]float64
+}
+
+func newStatsdEmitter(addr string, tags bool) (*statsdEmitter, error) {
+    conn, err := net.Dial("udp", addr)
+    if err != nil { return nil, err }
+    return &statsdEmitter{conn: conn, tags: tags, counters: make(map[string]float64)}, nil
+}
+
+// push sends the current registry content; only called from the polling goroutine
+func (e *statsdEmitter) push() {
+    mfs, err := registry.Gather()
+    if err != nil {
+        log.Printf("ERROR: StatsD gather: %v", err)
+        return
+    }
+
+    var buf bytes.Buffer
+    for _, mf := range mfs {
+        name := strings.Replace(mf.GetName(), appName+"_", appName+".", 1)
+        for _, m := range mf.Metric {
+            var line string
+            switch {
+            case m.Gauge != nil:
+                line = e.line(name, m.Label, m.Gauge.GetValue(), "g")
+            case m.Counter != nil:
+                key := e.key(name, m.Label)
+                v := m.Counter.GetValue()
+                prev, seen := e.counters[key]
+                e.counters[key] = v
+                // The first push only sets the baseline; a decrease means the series was recreated
+                if !seen || v < prev { continue }
+                line = e.line(name, m.Label, v-prev, "c")
+            default:
+                // summaries and histograms have no StatsD equivalent
+                continue
+            }
+            if buf.Len() > 0 && buf.Len()+len(line)+1 > statsdPacketSize { e.flush(&buf) }
+            if buf.Len() > 0 { buf.WriteByte('\n') }
+            buf.WriteString(line)
+        }
+    }
+    e.flush(&buf)
+}
+
+// line formats one sample, with labels as DogStatsD tags or folded into the dotted name
+func (e *statsdEmitter) line(name string, labels []*dto.LabelPair, value float64, kind string) string {
+    if !e.tags { return fmt.Sprintf("%s:%g|%s", e.key(name, labels), value, kind) }
+    if len(labels) == 0 { return fmt.Sprintf("%s:%g|%s", name, value, kind) }
+    tags := make([]string, 0, len(labels))
+    for _, lp := range labels { tags = append(tags, statsdSanitize(lp.GetName(), false)+":"+statsdSanitize(lp.GetValue(), false)) }
+    return fmt.Sprintf("%s:%g|%s|#%s", name, value, kind, strings.Join(tags, ","))
+}
+
+// key is the metric name extended by the label values in label name order (e.g. dockerstats.cpu_usage_ratio.0123456789ab.web)
+func (e *statsdEmitter) key(name string, labels []*dto.LabelPair) string {
+    sorted := append([]*dto.LabelPair(nil), labels...)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
+    parts := []string{name}
+    for _, lp := range sorted { parts = append(parts, statsdSanitize(lp.GetValue(), true)) }
+    return strings.Join(parts, ".")
+}
+
+func (e *statsdEmitter) flush(buf *bytes.Buffer) {
+    if buf.Len() == 0 { return }
+    if _, err := e.conn.Write(buf.Bytes()); err != nil { log.Printf("ERROR: StatsD write: %v", err) }
+    buf.Reset()
+}
+
+// statsdSanitize replaces characters with a meaning in the StatsD line format (and dots, when part of the name)
+func statsdSanitize(s string, inName bool) string {
+    if s == "" { return "none" }
+    return strings.Map(func(r rune) rune {
+        switch r {
+        case ':', '|', '@', '#', ',', ' ', '\n':
+            return '_'
+        case '.':
+            if inName { return '_' }
+        }
+        return r
+    }, s)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "context"
+    "path"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/docker/docker/client"
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+    maxTopCommands   = 20 // distinct commands exported per container, the rest is folded into "other"
+    maxTopCommandLen = 64
+)
+
+// Last process breakdown exported for a container
+type topSnapshot struct {
+    at       time.Time
+    commands map[string]float64
+}
+
+var (
+    topHistory = make(map[string]topSnapshot)
+    topMutex   sync.Mutex
+)
+
+// collectTop exports the number of processes per executable, at most once per -top-interval per container.
+// Containers where ContainerTop fails are skipped and retried on the next tick.
+func collectTop(ctx context.Context, cli *client.Client, cid string, labels prometheus.Labels) {
+    topMutex.Lock()
+    prev, found := topHistory[cid]
+    topMutex.Unlock()
+    if found && time.Since(prev.at) < *topInterval { return }
+
+    top, err := cli.ContainerTop(ctx, cid, nil)
+    if err != nil { return }
+
+    cmdCol := -1
+    for i, t := range top.Titles {
+        if t == "CMD" || t == "COMMAND" { cmdCol = i }
+    }
+    if cmdCol < 0 { return }
+
+    counts := make(map[string]float64)
+    for _, proc := range top.Processes {
+        if cmdCol >= len(proc) { continue }
+        cmd := "unknown"
+        if fields := strings.Fields(proc[cmdCol]); len(fields) > 0 { cmd = path.Base(fields[0]) }
+        if len(cmd) > maxTopCommandLen { cmd = cmd[:maxTopCommandLen] }
+        if _, ok := counts[cmd]; !ok && len(counts) >= maxTopCommands { cmd = "other" }
+        counts[cmd]++
+    }
+
+    for cmd, n := range counts {
+        gaugeProcessCount.With(extendLabels(labels, "command", cmd)).Set(n)
+    }
+    for cmd := range prev.commands {
+        if _, ok := counts[cmd]; !ok { gaugeProcessCount.Delete(extendLabels(labels, "command", cmd)) }
+    }
+
+    topMutex.Lock()
+    topHistory[cid] = topSnapshot{at: time.Now(), commands: counts}
+    topMutex.Unlock()
+}
+
+// extendLabels returns a copy of l with additional name, value pairs set
+func extendLabels(l prometheus.Labels, pairs ...string) prometheus.Labels {
+    out := make(prometheus.Labels, len(l)+len(pairs)/2)
+    for k, v := range l { out[k] = v }
+    for i := 0; i+1 < len(pairs); i += 2 { out[pairs[i]] = pairs[i+1] }
+    return out
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "path/filepath"
+
+    "github.com/docker/docker/api/types"
+    "github.com/docker/docker/api/types/mount"
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Set in main when -volume-quota is on and the daemon is local
+var volumeQuotaAvailable bool
+
+// collectVolumeQuota exports usage and size of the container's local volumes that are limited on their own:
+// a project quota (XFS, or overlay2 with pquota) or a dedicated filesystem. statfs on such a volume reports the
+// limit instead of the whole disk; volumes on the same filesystem as the other volumes, without a limit, are skipped.
+func collectVolumeQuota(cid string, mounts []types.MountPoint, labels prometheus.Labels) {
+    var series []prometheus.Labels
+    for _, m := range mounts {
+        if m.Type != mount.TypeVolume || m.Driver != "local" || m.Source == "" { continue }
+        size, used, err := diskUsage(m.Source)
+        if err != nil { continue }
+        // Source is <docker root>/volumes/<name>/_data
+        if total, _, err := diskUsage(filepath.Dir(filepath.Dir(m.Source))); err != nil || total == size { continue }
+
+        l := extendLabels(labels, "volume", m.Name)
+        gaugeVolumeUsed.With(l).Set(float64(used))
+        gaugeVolumeQuota.With(l).Set(float64(size))
+        series = append(series, l)
+    }
+    volumeUsedSeries.update(cid, series)
+    volumeQuotaSeries.update(cid, series)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "fmt"
+    "math"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "github.com/docker/docker/client"
+    "github.com/prometheus/client_golang/prometheus"
+    dto "github.com/prometheus/client_model/go"
+    "golang.org/x/time/rate"
+)
+
+// listenAndServe serves the endpoints over HTTP, or HTTPS with -web-cert; with -web-client-ca, only clients
+// presenting a certificate signed by that CA get through the handshake (mutual TLS). ready is called once
+// the certificates are loaded and the port is bound, right before serving.
+func listenAndServe(addr string, ready func()) error {
+    srv := &http.Server{Addr: addr}
+    var mode string
+    if *webCert != "" {
+        cert, err := tls.LoadX509KeyPair(*webCert, *webKey)
+        if err != nil { return fmt.Errorf("loading certificate: %w", err) }
+        srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+        mode = "HTTPS"
+    }
+    if *webClientCA != "" {
+        pem, err := os.ReadFile(*webClientCA)
+        if err != nil { return fmt.Errorf("reading client CA: %w", err) }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) { return fmt.Errorf("no certificates found in %s", *webClientCA) }
+        srv.TLSConfig.ClientCAs = pool
+        srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+        mode = "HTTPS, client certificates required"
+    }
+
+    ln, err := net.Listen("tcp", addr)
+    if err != nil { return err }
+    ready()
+    if srv.TLSConfig == nil {
+        logInfo("%s listening on %s", fullProgName, addr)
+        return srv.Serve(ln)
+    }
+    logInfo("%s listening on %s (%s)", fullProgName, addr, mode)
+    return srv.ServeTLS(ln, "", "")
+}
+
+// rateLimited answers 429 (with Retry-After) when requests arrive faster than perSecond
+func rateLimited(next http.Handler, perSecond float64) http.Handler {
+    limiter := rate.NewLimiter(rate.Limit(perSecond), 1)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        res := limiter.Reserve()
+        if d := res.Delay(); d > 0 {
+            res.Cancel()
+            w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+            http.Error(w, "Scrape rate limit exceeded", http.StatusTooManyRequests)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// filteredMetrics serves only the series matching ?name=<container name> and/or ?label=<label>:<value>
+// (repeatable, all must match), so tenants sharing one exporter can scrape just their containers.
+// Series without the label, e.g. host level metrics, are left out. Requests without filters go to next.
+func filteredMetrics(g prometheus.Gatherer, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        want := make(map[string]string)
+        for _, name := range q["name"] { want["name"] = name }
+        for _, sel := range q["label"] {
+            k, v, ok := strings.Cut(sel, ":")
+            if !ok || k == "" {
+                http.Error(w, fmt.Sprintf("Invalid label filter %q (expected label:value)", sel), http.StatusBadRequest)
+                return
+            }
+            want[k] = v
+        }
+        if len(want) == 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        filtered := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+            mfs, err := g.Gather()
+            out := mfs[:0]
+            for _, mf := range mfs {
+                metrics := mf.Metric[:0]
+                for _, m := range mf.Metric {
+                    if seriesMatches(m, want) { metrics = append(metrics, m) }
+                }
+                if len(metrics) == 0 { continue }
+                mf.Metric = metrics
+                out = append(out, mf)
+            }
+            return out, err
+        })
+        metricsHandler(filtered).ServeHTTP(w, r)
+    })
+}
+
+// seriesMatches reports whether a series carries every wanted label value
+func seriesMatches(m *dto.Metric, want map[string]string) bool {
+    matched := 0
+    for _, lp := range m.Label {
+        v, ok := want[lp.GetName()]
+        if !ok { continue }
+        if lp.GetValue() != v { return false }
+        matched++
+    }
+    return matched == len(want)
+}
+
+// handleCollect runs a gather cycle right away and answers once it is done.
+// A cycle that is already running (polling loop) is waited for first.
+func handleCollect(cli *client.Client) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            w.Header().Set("Allow", http.MethodPost)
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        start := time.Now()
+        gatherMetrics(cli)
+        fmt.Fprintf(w, "Collected in %v\n", time.Since(start).Round(time.Millisecond))
+    }
+}
+
+// handleHealth answers OK, or with -strict-health 503 while the last gather cycle failed to list
+// containers (daemon unreachable) or to read the stats of some of them
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+    if *strictHealth && lastGatherFailed.Load() {
+        http.Error(w, "Last collection cycle had errors", http.StatusServiceUnavailable)
+        return
+    }
+    w.Write([]byte("OK"))
+}
+
+// handleReady answers 503 until the first gather cycle finished, and while fewer than -min-containers
+// containers are collectable (a host that should never be empty usually means something broke upstream)
+func handleReady(w http.ResponseWriter, r *http.Request) {
+    if !initialDiscoveryDone.Load() {
+        http.Error(w, "Not ready: first collection pending", http.StatusServiceUnavailable)
+        return
+    }
+    if n := collectableCount.Load(); n < int64(*minContainers) {
+        http.Error(w, fmt.Sprintf("Not ready: %d containers, expected at least %d (-min-containers)", n, *minContainers), http.StatusServiceUnavailable)
+        return
+    }
+    w.Write([]byte("OK"))
+}
+
+// Healthcheck states of the running containers, as served by /health/summary
+type healthSummary struct {
+    Healthy   int       `json:"healthy"`
+    Unhealthy int       `json:"unhealthy"`
+    Starting  int       `json:"starting"`
+    None      int       `json:"none"` // no healthcheck configured
+    Updated   time.Time `json:"updated"`
+}
+
+// Summary of the last gather cycle
+var lastHealth atomic.Pointer[healthSummary]
+
+// countHealth adds a container to the summary, going by the status text of the listing,
+// e.g. "Up 5 minutes (healthy)", "Up 3 seconds (health: starting)"
+func (h *healthSummary) countHealth(status string) {
+    switch {
+    case strings.HasSuffix(status, "(healthy)"): h.Healthy++
+    case strings.HasSuffix(status, "(unhealthy)"): h.Unhealthy++
+    case strings.HasSuffix(status, "(health: starting)"): h.Starting++
+    default: h.None++
+    }
+}
+
+// handleHealthSummary answers with the healthcheck counts as JSON, always with 200, for uptime checks
+// that don't speak the Prometheus format
+func handleHealthSummary(w http.ResponseWriter, r *http.Request) {
+    summary := lastHealth.Load()
+    if summary == nil { summary = &healthSummary{} }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(summary)
+}